@@ -0,0 +1,232 @@
+/*
+Package analyzer provides a go/analysis Analyzer that runs tmplcheck on
+each package, so that tmplcheck can be used with go vet -vettool,
+singlechecker and multichecker.
+
+Missing keys are reported at the Execute call in go source, with related
+information pointing to the use of the key in the template.
+
+The templates directory is set with the -templates flag. A relative path
+is resolved relative to the directory of the package being analyzed.
+*/
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/tools/go/analysis"
+
+	"github.com/go-web-framework/tmplcheck"
+)
+
+// Analyzer reports keys used in templates that are missing from the
+// data passed in Execute calls.
+var Analyzer = &analysis.Analyzer{
+	Name: "tmplcheck",
+	Doc:  "check that templates do not use keys not passed in Execute calls",
+	URL:  "https://github.com/go-web-framework/tmplcheck",
+	Run:  run,
+}
+
+var (
+	templatesPath string
+	leftDelim     string
+	rightDelim    string
+	unverifiable  bool
+)
+
+func init() {
+	Analyzer.Flags.StringVar(&templatesPath, "templates", "", "path to templates directory, relative to the package directory")
+	Analyzer.Flags.StringVar(&leftDelim, "ldelim", "{{", "left delimiter in templates")
+	Analyzer.Flags.StringVar(&rightDelim, "rdelim", "}}", "right delimiter in templates")
+	Analyzer.Flags.BoolVar(&unverifiable, "unverifiable", false, "also report Execute calls that cannot be verified")
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	usages, unverifiableUsages, unattached := tmplcheck.FindUsages(pass.Fset, pass.Files, pass.TypesInfo)
+	if len(usages) == 0 && len(unverifiableUsages) == 0 {
+		return nil, nil
+	}
+
+	if templatesPath == "" {
+		return nil, errors.New("tmplcheck: -templates is required")
+	}
+	root := templatesPath
+	if !filepath.IsAbs(root) && len(pass.Files) != 0 {
+		dir := filepath.Dir(pass.Fset.File(pass.Files[0].Pos()).Name())
+		root = filepath.Join(dir, root)
+	}
+
+	res, err := tmplcheck.CheckUsages(context.Background(), &tmplcheck.Config{
+		Templates:  root,
+		LeftDelim:  leftDelim,
+		RightDelim: rightDelim,
+	}, usages, unverifiableUsages, unattached)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range res.Templates {
+		// Unverifiable usages and unused directives in go source are
+		// those of this package.
+		if unverifiable {
+			for _, u := range r.Unverifiable {
+				pass.Report(analysis.Diagnostic{
+					Pos:      u.Usage.Pos,
+					Category: tmplcheck.RuleUnverifiable,
+					Message:  fmt.Sprintf("%s.%s cannot be verified: %s", u.Usage.Obj, u.Usage.Call, u.Reason),
+				})
+			}
+		}
+		for _, u := range r.Unknown {
+			pass.Report(analysis.Diagnostic{
+				Pos:      u.Usage.Pos,
+				Category: tmplcheck.RuleUnknownTemplate,
+				Message:  fmt.Sprintf("%s.%s executes unknown template %q", u.Usage.Obj, u.Usage.Call, u.Usage.Template) + tmplcheck.DidYouMean(u.Suggestions),
+			})
+		}
+		for _, u := range r.Unused {
+			if u.Usage == nil {
+				continue
+			}
+			msg := "unused tmplcheck:ignore directive"
+			if rule := u.Suppression.Rule; rule != "" && tmplcheck.LookupRule(rule) == nil {
+				msg = fmt.Sprintf("unknown rule %q in tmplcheck:ignore directive", rule)
+			}
+			pass.Report(analysis.Diagnostic{
+				Pos:      goLinePos(pass.Fset, u.Usage.Pos, u.Suppression.Line),
+				Category: tmplcheck.RuleUnusedSuppression,
+				Message:  msg,
+			})
+		}
+
+		// Only report for templates executed in this package, since
+		// every package that uses templates checks all of them.
+		if len(usages[r.Template]) == 0 {
+			continue
+		}
+
+		if r.ParseErr != nil {
+			pass.Report(analysis.Diagnostic{
+				Pos:      templatePos(pass.Fset, root, r.ParseErr.Path, r.ParseErr.Line, -1),
+				Category: tmplcheck.RuleParseError,
+				Message:  fmt.Sprintf("template %s: %s", r.Template, r.ParseErr.Msg),
+			})
+		}
+
+		for _, e := range r.FuncArgs {
+			pass.Report(analysis.Diagnostic{
+				Pos:      templatePos(pass.Fset, root, e.TemplateIdent.Path, e.TemplateIdent.Line, int(e.TemplateIdent.Pos)),
+				End:      templateEnd(pass.Fset, root, e.TemplateIdent),
+				Category: tmplcheck.RuleBadFuncArgs,
+				Message: fmt.Sprintf("template %s: wrong number of arguments for %s: want %s, got %d",
+					r.Template, e.Func, e.Want, e.Got),
+			})
+		}
+
+		for _, m := range r.Missing {
+			pass.Report(analysis.Diagnostic{
+				Pos:      m.Usage.Pos,
+				Category: tmplcheck.RuleMissingKey,
+				Message: fmt.Sprintf("%s.%s is missing %q, used by template %s",
+					m.Usage.Obj, m.Usage.Call, m.MissingKey, r.Template) + tmplcheck.DidYouMean(m.Suggestions),
+				Related: []analysis.RelatedInformation{{
+					Pos:     templatePos(pass.Fset, root, m.TemplateIdent.Path, m.TemplateIdent.Line, int(m.TemplateIdent.Pos)),
+					End:     templateEnd(pass.Fset, root, m.TemplateIdent),
+					Message: fmt.Sprintf("%q used here", m.MissingKey),
+				}},
+			})
+		}
+
+		for _, u := range r.Unexported {
+			pass.Report(analysis.Diagnostic{
+				Pos:      u.Usage.Pos,
+				Category: tmplcheck.RuleUnexportedField,
+				Message: fmt.Sprintf("template %s uses unexported field %q of %s",
+					r.Template, u.Field, u.Type),
+				Related: []analysis.RelatedInformation{{
+					Pos:     templatePos(pass.Fset, root, u.TemplateIdent.Path, u.TemplateIdent.Line, int(u.TemplateIdent.Pos)),
+					End:     templateEnd(pass.Fset, root, u.TemplateIdent),
+					Message: fmt.Sprintf("%q used here", u.Field),
+				}},
+			})
+		}
+
+		for _, u := range r.UnusedKeys {
+			if u.Severity < tmplcheck.SeverityWarning {
+				continue // info by default, which vet has no notion of
+			}
+			pass.Report(analysis.Diagnostic{
+				Pos:      u.Usage.Pos,
+				Category: tmplcheck.RuleUnusedKey,
+				Message:  fmt.Sprintf("%s.%s passes %q, which is not used by template %s", u.Usage.Obj, u.Usage.Call, u.Key, r.Template),
+			})
+		}
+	}
+
+	return nil, nil
+}
+
+// goLinePos returns the position of the start of the line in the go
+// file containing pos.
+func goLinePos(fset *token.FileSet, pos token.Pos, line int) token.Pos {
+	f := fset.File(pos)
+	if f == nil || line < 1 || line > f.LineCount() {
+		return pos
+	}
+	return f.LineStart(line)
+}
+
+// templateFiles holds the *token.File added to a FileSet for each
+// template file, so that each template is added only once.
+var templateFiles sync.Map // templateFileKey -> *token.File
+
+type templateFileKey struct {
+	fset *token.FileSet
+	path string
+}
+
+// templatePos returns the position in fset of the byte offset in the
+// template file at relpath in root. If offset is negative, the position
+// is the start of line. The file is added to fset, as go/analysis
+// requires for diagnostics in non-Go files. NoPos is returned if the
+// file cannot be read.
+func templatePos(fset *token.FileSet, root, relpath string, line, offset int) token.Pos {
+	path := filepath.Join(root, relpath)
+	key := templateFileKey{fset, path}
+
+	v, ok := templateFiles.Load(key)
+	if !ok {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return token.NoPos
+		}
+		f := fset.AddFile(path, -1, len(b))
+		f.SetLinesForContent(b)
+		v, _ = templateFiles.LoadOrStore(key, f)
+	}
+	f := v.(*token.File)
+
+	switch {
+	case offset >= 0 && offset <= f.Size():
+		return f.Pos(offset)
+	case line >= 1 && line <= f.LineCount():
+		return f.LineStart(line)
+	}
+	return f.Pos(0)
+}
+
+// templateEnd returns the position of the end of the identifiers in the
+// template, or token.NoPos if it is unknown.
+func templateEnd(fset *token.FileSet, root string, ti tmplcheck.TemplateIdent) token.Pos {
+	if ti.End <= ti.Pos {
+		return token.NoPos
+	}
+	return templatePos(fset, root, ti.Path, ti.EndLine, int(ti.End))
+}
@@ -0,0 +1,16 @@
+package analyzer
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	if err := Analyzer.Flags.Set("templates", "templates"); err != nil {
+		t.Fatal(err)
+	}
+	defer Analyzer.Flags.Set("templates", "")
+
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "a")
+}
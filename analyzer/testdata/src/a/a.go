@@ -0,0 +1,18 @@
+package a
+
+import (
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+type page struct {
+	Title string
+	X     int
+}
+
+func render(set *templates.Set, data interface{}) {
+	set.Execute("root.html", os.Stdout, page{Title: "t", X: 1}) // want `set.Execute is missing "Y", used by template root.html`
+	set.Execute("root.html", os.Stdout, nil)                    // want `set.Execute is missing "Title"` `set.Execute is missing "X"` `set.Execute is missing "Y"`
+	set.Execute("root.html", os.Stdout, data)
+}
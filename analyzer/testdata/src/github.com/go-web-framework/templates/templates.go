@@ -0,0 +1,11 @@
+// Package templates is a stub of github.com/go-web-framework/templates
+// for tests.
+package templates
+
+import "io"
+
+type Set struct{}
+
+func (s *Set) Parse(dir string) error { return nil }
+
+func (s *Set) Execute(name string, w io.Writer, data interface{}) error { return nil }
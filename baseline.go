@@ -0,0 +1,215 @@
+package tmplcheck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Fingerprints identify findings across runs. They are made of the
+// template path, the rule, the key or reason, the call expression, and
+// the text around the finding, but not of line numbers, so that they do
+// not change when unrelated lines are added or removed. Go source files
+// are identified by Usage.Path, so that files of the same name in
+// different directories have different fingerprints.
+
+// fingerprint returns the hex-encoded hash of the parts.
+func fingerprint(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (e MissingError) Fingerprint() string {
+	return fingerprint(RuleMissingKey, e.TemplateIdent.Path, e.MissingKey, e.Usage.Expr, strings.TrimSpace(e.TemplateIdent.LineText))
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (e ParseError) Fingerprint() string {
+	return fingerprint(RuleParseError, e.Path, e.Msg)
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (e UnverifiableUsage) Fingerprint() string {
+	return fingerprint(RuleUnverifiable, e.Usage.Template, e.Usage.Path, e.Usage.Expr, e.Reason)
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (e UnusedSuppression) Fingerprint() string {
+	p, expr := e.Suppression.Path, ""
+	if e.Usage != nil { // in go source
+		p, expr = e.Usage.Path, e.Usage.Expr
+	}
+	return fingerprint(RuleUnusedSuppression, p, e.Suppression.Rule, e.Suppression.Reason, expr)
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (e UnusedKey) Fingerprint() string {
+	return fingerprint(RuleUnusedKey, e.Usage.Template, e.Usage.Path, e.Usage.Expr, e.Key)
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (e UnknownTemplate) Fingerprint() string {
+	return fingerprint(RuleUnknownTemplate, e.Usage.Template, e.Usage.Path, e.Usage.Expr)
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (e UnexportedField) Fingerprint() string {
+	return fingerprint(RuleUnexportedField, e.TemplateIdent.Path, e.Field, e.Usage.Expr, strings.TrimSpace(e.TemplateIdent.LineText))
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (e FuncArgsError) Fingerprint() string {
+	return fingerprint(RuleBadFuncArgs, e.TemplateIdent.Path, e.Func, strings.TrimSpace(e.TemplateIdent.LineText))
+}
+
+// baselineVersion is the version of the baseline file format.
+const baselineVersion = 1
+
+// Baseline is a snapshot of findings, typically written when adopting
+// tmplcheck in an existing code base, so that later checks report only
+// new findings. Findings are matched by fingerprint, and each entry
+// matches as many findings as its count.
+type Baseline struct {
+	Version  int             `json:"version"`
+	Findings []BaselineEntry `json:"findings"`
+}
+
+// BaselineEntry is the findings in a Baseline with the same fingerprint.
+type BaselineEntry struct {
+	Template    string `json:"template"` // path of template file, including TemplateResult.Root
+	Rule        string `json:"rule"`
+	Fingerprint string `json:"fingerprint"`
+	Message     string `json:"message"` // description of the finding, for readers of the file
+	Count       int    `json:"count"`
+}
+
+// baselineKey identifies the findings matched by a BaselineEntry.
+type baselineKey struct {
+	template    string
+	fingerprint string
+}
+
+func (e BaselineEntry) key() baselineKey {
+	return baselineKey{e.Template, e.Fingerprint}
+}
+
+// NewBaseline returns a baseline of the findings in the result.
+func NewBaseline(res *Result) *Baseline {
+	index := make(map[baselineKey]int)
+	b := &Baseline{Version: baselineVersion, Findings: []BaselineEntry{}}
+	for i := range res.Templates {
+		filterFindings(&res.Templates[i], func(e BaselineEntry) bool {
+			if j, ok := index[e.key()]; ok {
+				b.Findings[j].Count++
+				return true
+			}
+			e.Count = 1
+			index[e.key()] = len(b.Findings)
+			b.Findings = append(b.Findings, e)
+			return true
+		})
+	}
+	b.sort()
+	return b
+}
+
+// ReadBaseline reads the baseline file at path.
+func ReadBaseline(path string) (*Baseline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if b.Version != baselineVersion {
+		return nil, fmt.Errorf("%s: unsupported baseline version %d", path, b.Version)
+	}
+	return &b, nil
+}
+
+// WriteFile writes the baseline to the file at path.
+func (b *Baseline) WriteFile(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Filter removes the findings in the baseline from the result. It
+// returns the entries of the baseline that matched fewer findings than
+// their count, with the count set to the difference: these findings
+// were fixed and the entries can be removed from the baseline.
+func (b *Baseline) Filter(res *Result) []BaselineEntry {
+	remaining := make(map[baselineKey]int)
+	for _, e := range b.Findings {
+		remaining[e.key()] += e.Count
+	}
+	for i := range res.Templates {
+		filterFindings(&res.Templates[i], func(e BaselineEntry) bool {
+			if remaining[e.key()] > 0 {
+				remaining[e.key()]--
+				return false
+			}
+			return true
+		})
+	}
+
+	var fixed []BaselineEntry
+	for _, e := range b.Findings {
+		if n := remaining[e.key()]; n > 0 {
+			e.Count = n
+			remaining[e.key()] = 0 // in case of duplicate entries
+			fixed = append(fixed, e)
+		}
+	}
+	return fixed
+}
+
+func (b *Baseline) sort() {
+	sort.Slice(b.Findings, func(i, j int) bool {
+		x, y := b.Findings[i], b.Findings[j]
+		if x.Template != y.Template {
+			return x.Template < y.Template
+		}
+		if x.Rule != y.Rule {
+			return x.Rule < y.Rule
+		}
+		return x.Fingerprint < y.Fingerprint
+	})
+}
+
+// filterFindings calls keep for each finding in the result, as an
+// entry with a zero count, and removes the findings for which it
+// returns false.
+func filterFindings(r *TemplateResult, keep func(BaselineEntry) bool) {
+	template := filepath.ToSlash(filepath.Join(r.Root, r.Template))
+	r.Filter(func(f Finding) bool {
+		return keep(BaselineEntry{
+			Template:    template,
+			Rule:        f.Rule.ID,
+			Fingerprint: f.Fingerprint(),
+			Message:     f.Message,
+		})
+	})
+}
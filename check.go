@@ -1,47 +1,92 @@
-package main
+package tmplcheck
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+
+	"github.com/go-web-framework/tmplcheck/visit"
 )
 
+// MissingError is a key used in a template that is not passed by an
+// Execute call.
 type MissingError struct {
 	Usage         Usage
 	TemplateIdent TemplateIdent
 	MissingKey    string
+	Severity      Severity
+
+	// TemplateIdents and Usages are set by TemplateResult.Group to all
+	// the uses of the key in the template and all the Execute calls that
+	// do not pass it. TemplateIdent and Usage are the first of them.
+	TemplateIdents []TemplateIdent
+	Usages         []Usage
+
+	// Suggestions are the keys or fields that MissingKey may be a
+	// misspelling of, closest first.
+	Suggestions []string
+
+	// Fix is the edit of the template that replaces the key with the
+	// suggestion that is almost certainly meant, if any. See
+	// Result.Fixes.
+	Fix *Edit
+
+	index  int            // of MissingKey in TemplateIdent.Idents
+	merged []MissingError // the findings merged by Group, in order
 }
 
 func (e MissingError) MarshalJSON() ([]byte, error) {
 	type t struct {
-		Path string `json:"file"`
-		Line int    `json:"line"`
-		Col  int    `json:"col"`
+		Path    string `json:"file"`
+		Line    int    `json:"line"`
+		Col     int    `json:"col"`
+		EndLine int    `json:"end_line"`
+		EndCol  int    `json:"end_col"`
 	}
 
 	type s struct {
 		Path       string `json:"file"`
 		Line       int    `json:"line"`
+		Col        int    `json:"col"`
 		Key        string `json:"key"`
 		MethodCall string `json:"call"`
 	}
 
+	toT := func(ti TemplateIdent) t {
+		return t{ti.Path, ti.Line, ti.Col, ti.EndLine, ti.EndCol}
+	}
+	toS := func(u Usage) s {
+		return s{u.Path, u.Line, u.Col, e.MissingKey, u.Obj + "." + u.Call}
+	}
+
 	aux := struct {
-		Template t `json:"template"`
-		Source   s `json:"source"`
+		Template    t        `json:"template"`
+		Source      s        `json:"source"`
+		Rule        string   `json:"rule"`
+		Severity    Severity `json:"severity"`
+		Templates   []t      `json:"templates,omitempty"`
+		Sources     []s      `json:"sources,omitempty"`
+		Suggestions []string `json:"suggestions,omitempty"`
 	}{
-		t{
-			e.TemplateIdent.Path,
-			e.TemplateIdent.Line,
-			e.TemplateIdent.Col,
-		},
-		s{
-			e.Usage.Path,
-			e.Usage.Line,
-			e.MissingKey,
-			e.Usage.Obj + "." + e.Usage.Call,
-		},
+		Template:    toT(e.TemplateIdent),
+		Source:      toS(e.Usage),
+		Rule:        RuleMissingKey,
+		Severity:    e.Severity,
+		Suggestions: e.Suggestions,
+	}
+	// Grouped findings list all their positions.
+	for _, ti := range e.TemplateIdents {
+		aux.Templates = append(aux.Templates, toT(ti))
+	}
+	for _, u := range e.Usages {
+		aux.Sources = append(aux.Sources, toS(u))
 	}
 
 	return json.Marshal(aux)
@@ -49,111 +94,528 @@ func (e MissingError) MarshalJSON() ([]byte, error) {
 
 func (e MissingError) String() string {
 	return fmt.Sprintf(
-		"%d:%d: uses %q, but %s:%d: %s.%s is missing %q",
-		e.TemplateIdent.Line, e.TemplateIdent.Col,
-		e.MissingKey, e.Usage.Path, e.Usage.Line, e.Usage.Obj, e.Usage.Call, e.MissingKey,
+		"%d:%d: %suses %q, but %s:%d: %s.%s is missing %q [%s]",
+		e.TemplateIdent.Line, e.TemplateIdent.Col, e.Severity.label(),
+		e.MissingKey, e.Usage.Path, e.Usage.Line, e.Usage.Obj, e.Usage.Call, e.MissingKey, RuleMissingKey,
 	)
 }
 
-type checkResult struct {
-	Template string         `json:"template"` // path of template file
-	Errs     []MissingError `json:"missing"`
+func (e MissingError) message() string {
+	key := fmt.Sprintf("%q", e.MissingKey)
+	if n := len(e.TemplateIdents); n > 1 {
+		key += fmt.Sprintf(", used %d times,", n)
+	}
+	msg := fmt.Sprintf("%s is missing from the data passed by %s.%s", key, e.Usage.Obj, e.Usage.Call)
+	switch n := len(e.Usages) - 1; {
+	case n == 1:
+		msg += " and 1 other call"
+	case n > 1:
+		msg += fmt.Sprintf(" and %d other calls", n)
+	}
+	return msg + DidYouMean(e.Suggestions)
 }
 
-func (c checkResult) String() string {
-	buf := bytes.Buffer{}
-	buf.WriteString(fmt.Sprintf("%s\n", c.Template))
-	for i, e := range c.Errs {
-		buf.WriteString(fmt.Sprintf("%s", e))
-		if i != len(c.Errs)-1 {
-			buf.WriteString("\n")
+// ParseError is a template that could not be parsed. Templates with
+// parse errors are not checked against the go source.
+type ParseError struct {
+	Path string // relative path of template file
+	Line int    // 0 if unknown
+	Col  int    // 0 if unknown
+	Msg  string
+
+	Severity Severity
+}
+
+func (e ParseError) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		Path string   `json:"file"`
+		Line int      `json:"line"`
+		Col  int      `json:"col"`
+		Msg  string   `json:"message"`
+		Rule string   `json:"rule"`
+		Sev  Severity `json:"severity"`
+	}{
+		e.Path,
+		e.Line,
+		e.Col,
+		e.Msg,
+		RuleParseError,
+		e.Severity,
+	}
+
+	return json.Marshal(aux)
+}
+
+func (e ParseError) String() string {
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("%sparse error: %s [%s]", e.Severity.label(), e.Msg, RuleParseError)
+	case e.Col == 0:
+		return fmt.Sprintf("%d: %sparse error: %s [%s]", e.Line, e.Severity.label(), e.Msg, RuleParseError)
+	default:
+		return fmt.Sprintf("%d:%d: %sparse error: %s [%s]", e.Line, e.Col, e.Severity.label(), e.Msg, RuleParseError)
+	}
+}
+
+func (e UnverifiableUsage) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		Path       string   `json:"file"`
+		Line       int      `json:"line"`
+		Col        int      `json:"col"`
+		MethodCall string   `json:"call"`
+		Reason     string   `json:"reason"`
+		Rule       string   `json:"rule"`
+		Severity   Severity `json:"severity"`
+	}{
+		e.Usage.Path,
+		e.Usage.Line,
+		e.Usage.Col,
+		e.Usage.Obj + "." + e.Usage.Call,
+		e.Reason,
+		RuleUnverifiable,
+		e.Severity,
+	}
+
+	return json.Marshal(aux)
+}
+
+func (e UnverifiableUsage) String() string {
+	return fmt.Sprintf("%s:%d: %s%s [%s]", e.Usage.Path, e.Usage.Line, e.Severity.label(), e.message(), RuleUnverifiable)
+}
+
+func (e UnverifiableUsage) message() string {
+	return fmt.Sprintf("%s.%s cannot be verified: %s", e.Usage.Obj, e.Usage.Call, e.Reason)
+}
+
+// UnusedKey is a key passed by an Execute call that is not used in the
+// template.
+type UnusedKey struct {
+	Usage    Usage
+	Key      string
+	Severity Severity
+}
+
+func (e UnusedKey) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		Path       string   `json:"file"`
+		Line       int      `json:"line"`
+		Col        int      `json:"col"`
+		MethodCall string   `json:"call"`
+		Key        string   `json:"key"`
+		Rule       string   `json:"rule"`
+		Severity   Severity `json:"severity"`
+	}{
+		e.Usage.Path,
+		e.Usage.Line,
+		e.Usage.Col,
+		e.Usage.Obj + "." + e.Usage.Call,
+		e.Key,
+		RuleUnusedKey,
+		e.Severity,
+	}
+
+	return json.Marshal(aux)
+}
+
+func (e UnusedKey) String() string {
+	return fmt.Sprintf("%s:%d: %s%s [%s]", e.Usage.Path, e.Usage.Line, e.Severity.label(), e.message(), RuleUnusedKey)
+}
+
+func (e UnusedKey) message() string {
+	return fmt.Sprintf("%s.%s passes %q, which is not used by the template", e.Usage.Obj, e.Usage.Call, e.Key)
+}
+
+// UnknownTemplate is an Execute call for a template that is not in the
+// templates directory.
+type UnknownTemplate struct {
+	Usage    Usage
+	Severity Severity
+
+	// Suggestions are the templates that the name may be a misspelling
+	// of, closest first.
+	Suggestions []string
+}
+
+func (e UnknownTemplate) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		Path        string   `json:"file"`
+		Line        int      `json:"line"`
+		Col         int      `json:"col"`
+		MethodCall  string   `json:"call"`
+		Template    string   `json:"template"`
+		Rule        string   `json:"rule"`
+		Severity    Severity `json:"severity"`
+		Suggestions []string `json:"suggestions,omitempty"`
+	}{
+		e.Usage.Path,
+		e.Usage.Line,
+		e.Usage.Col,
+		e.Usage.Obj + "." + e.Usage.Call,
+		e.Usage.Template,
+		RuleUnknownTemplate,
+		e.Severity,
+		e.Suggestions,
+	}
+
+	return json.Marshal(aux)
+}
+
+func (e UnknownTemplate) String() string {
+	return fmt.Sprintf("%s:%d: %s%s [%s]", e.Usage.Path, e.Usage.Line, e.Severity.label(), e.message(), RuleUnknownTemplate)
+}
+
+func (e UnknownTemplate) message() string {
+	return fmt.Sprintf("%s.%s executes unknown template %q", e.Usage.Obj, e.Usage.Call, e.Usage.Template) + DidYouMean(e.Suggestions)
+}
+
+// Result is the result of Check.
+type Result struct {
+	Templates []TemplateResult // sorted by Root and Template; see Sort
+
+	// Base is the directory that output formats such as sarif make paths
+	// relative to, typically the root of the repository. The working
+	// directory is used if it is empty.
+	Base string
+}
+
+// Count returns the number of findings with the severity.
+func (r *Result) Count(sev Severity) int {
+	n := 0
+	for _, f := range r.Findings() {
+		if f.Severity == sev {
+			n++
 		}
 	}
+	return n
+}
+
+// TemplateResult is the result of checking a template.
+type TemplateResult struct {
+	Template     string              `json:"template"`       // path of template file; empty if the usages' template is unknown
+	Root         string              `json:"root,omitempty"` // templates directory; set when results for several directories are combined
+	Dir          string              `json:"-"`              // templates directory, as given in Config
+	Missing      []MissingError      `json:"missing"`
+	ParseErr     *ParseError         `json:"parse_error,omitempty"`
+	UnusedKeys   []UnusedKey         `json:"unused_keys,omitempty"`
+	Unknown      []UnknownTemplate   `json:"unknown_template,omitempty"`
+	Unexported   []UnexportedField   `json:"unexported_fields,omitempty"`
+	FuncArgs     []FuncArgsError     `json:"bad_func_args,omitempty"`
+	Unverifiable []UnverifiableUsage `json:"unverifiable,omitempty"`
+	Unused       []UnusedSuppression `json:"unused_suppressions,omitempty"`
+}
+
+func (c TemplateResult) String() string {
+	var lines []string
+	for _, f := range c.Findings() {
+		lines = append(lines, fmt.Sprint(f.Value))
+	}
+
+	name := c.Template
+	if name == "" {
+		name = "<unknown template>"
+	}
+	if c.Root != "" {
+		name = filepath.Join(c.Root, name)
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("%s\n", name))
+	buf.WriteString(strings.Join(lines, "\n"))
 	return buf.String()
 }
 
-// DoAll returns the results of checking the source files against the
-// template files. The flag global variables are expected to be set when
-// DoAll is called.
-func DoAll() []checkResult {
-	usages, identsForTemplate, err := goParseAll(PackagePath, TemplatesPath)
-	if err != nil {
-		exitErr(err)
+// templateExists reports whether the template named name exists in
+// the templates directory, even if it is not checked.
+func (c *Config) templateExists(name string) bool {
+	if _, ok := c.Overlay[name]; ok {
+		return true
 	}
-	return doCheck(usages, identsForTemplate)
+	info, err := os.Stat(filepath.Join(c.Templates, filepath.FromSlash(name)))
+	return err == nil && info.Mode().IsRegular()
 }
 
-func goParseAll(ppath, tpath string) (map[string][]Usage, map[string][]TemplateIdent, error) {
+func goParseAll(ctx context.Context, cfg *Config) (map[string][]Usage, []UnverifiableUsage, []UnusedSuppression, map[string]parsedTemplate, map[string]ParseError, error) {
 	var wg sync.WaitGroup
 
 	var usages map[string][]Usage
-	var identsForTemplate map[string][]TemplateIdent
+	var unverifiable []UnverifiableUsage
+	var unattached []UnusedSuppression
+	var templates map[string]parsedTemplate
+	var parseErrs map[string]ParseError
 	var err0, err1 error
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		usages, err0 = parsePackage(ppath)
+		usages, unverifiable, unattached, err0 = parsePackages(ctx, cfg.ImportPaths())
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		identsForTemplate, err1 = parseTemplates(tpath)
+		templates, parseErrs, err1 = parseTemplates(ctx, cfg)
 	}()
 
 	wg.Wait()
 
 	if err0 != nil {
-		return nil, nil, err0
+		return nil, nil, nil, nil, nil, err0
 	}
 	if err1 != nil {
-		return nil, nil, err1
+		return nil, nil, nil, nil, nil, err1
 	}
 
-	return usages, identsForTemplate, nil
+	return usages, unverifiable, unattached, templates, parseErrs, nil
 }
 
 // doCheck compares the usages (in go source) with the identifiers used in
-// templates. One checkResult for each template is returned.
-func doCheck(usages map[string][]Usage, identsForTemplate map[string][]TemplateIdent) []checkResult {
-	var results []checkResult
+// templates. One TemplateResult for each template is returned, including
+// for templates that failed to parse and for unknown templates.
+// Unverifiable usages and unused suppressions in go source are added to
+// the result of the template they execute, and unattached directives in
+// go source to that of the unknown template "". Severities are set from cfg,
+// and the result is sorted. If only is not nil, only the templates named
+// in it are checked, and the result has only their findings.
+func doCheck(cfg *Config, usages map[string][]Usage, unverifiable []UnverifiableUsage, unattached []UnusedSuppression, templates map[string]parsedTemplate, parseErrs map[string]ParseError, only map[string]bool) *Result {
+	var results []TemplateResult
+	index := make(map[string]int) // template name -> index in results
+	used := make(map[*Suppression]bool)
+	want := func(name string) bool { return only == nil || only[name] }
+
+	resultFor := func(name string) *TemplateResult {
+		i, ok := index[name]
+		if !ok {
+			i = len(results)
+			index[name] = i
+			results = append(results, TemplateResult{Template: name})
+		}
+		return &results[i]
+	}
 
-	for k, v := range identsForTemplate {
+	for k, v := range templates {
+		if !want(k) {
+			continue
+		}
 		u := usages[k]
-		r := check(v, u)
+		r := check(v.idents, u, used)
 		r.Template = k
+		r.UnusedKeys = unusedKeys(v, u, used)
+		for _, u := range u {
+			for _, e := range unexportedFields(v, u) {
+				if s := suppressedBy(RuleUnexportedField, e.TemplateIdent.Suppression, u.Suppression); s != nil {
+					used[s] = true
+					continue
+				}
+				r.Unexported = append(r.Unexported, e)
+			}
+		}
+		for _, e := range v.funcArgs {
+			if s := e.TemplateIdent.Suppression; s.suppresses(RuleBadFuncArgs) {
+				used[s] = true
+				continue
+			}
+			r.FuncArgs = append(r.FuncArgs, e)
+		}
+		index[k] = len(results)
 		results = append(results, r)
 	}
 
-	return results
+	for k, e := range parseErrs {
+		if !want(k) {
+			continue
+		}
+		e := e
+		index[k] = len(results)
+		results = append(results, TemplateResult{Template: k, ParseErr: &e})
+	}
+
+	names := make([]string, 0, len(usages))
+	for k := range usages {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var known []string // for suggestions
+	for k := range templates {
+		known = append(known, k)
+	}
+	for k := range parseErrs {
+		known = append(known, k)
+	}
+	sort.Strings(known)
+	for _, k := range names {
+		if !want(k) || containsString(known, k) || cfg.templateExists(k) {
+			continue
+		}
+		for _, u := range usages[k] {
+			if s := u.Suppression; s.suppresses(RuleUnknownTemplate) {
+				used[s] = true
+				continue
+			}
+			r := resultFor(k)
+			r.Unknown = append(r.Unknown, UnknownTemplate{Usage: u, Suggestions: suggest(k, known)})
+		}
+	}
+
+	for _, u := range unverifiable {
+		if !want(u.Usage.Template) {
+			continue
+		}
+		if s := u.Usage.Suppression; s.suppresses(RuleUnverifiable) {
+			used[s] = true
+			continue
+		}
+		r := resultFor(u.Usage.Template)
+		r.Unverifiable = append(r.Unverifiable, u)
+	}
+
+	for k, v := range templates {
+		if !want(k) {
+			continue
+		}
+		for _, s := range v.suppressions {
+			if !used[s] {
+				r := resultFor(k)
+				r.Unused = append(r.Unused, UnusedSuppression{Suppression: *s})
+			}
+		}
+	}
+
+	// Usages in the map and the unverifiable ones are distinct, and each
+	// go directive applies to at most one usage.
+	goUsages := make([]Usage, 0, len(unverifiable))
+	for _, us := range usages {
+		goUsages = append(goUsages, us...)
+	}
+	for _, u := range unverifiable {
+		goUsages = append(goUsages, u.Usage)
+	}
+	for _, u := range goUsages {
+		if !want(u.Template) {
+			continue
+		}
+		if s := u.Suppression; s != nil && !used[s] {
+			used[s] = true // report once
+			u := u
+			r := resultFor(u.Template)
+			r.Unused = append(r.Unused, UnusedSuppression{Suppression: *s, Usage: &u})
+		}
+	}
+	if want("") {
+		for _, e := range unattached {
+			r := resultFor("")
+			r.Unused = append(r.Unused, e)
+		}
+	}
+
+	for i := range results {
+		results[i].Dir = cfg.Templates
+		cfg.applySeverity(&results[i])
+	}
+
+	res := &Result{Templates: results}
+	res.Sort()
+	return res
 }
 
-func check(t []TemplateIdent, pkgUsages []Usage) checkResult {
+// check returns the missing keys for the identifiers of a template.
+// Suppressions that suppress a missing key are recorded in used.
+func check(t []TemplateIdent, pkgUsages []Usage, used map[*Suppression]bool) TemplateResult {
 	// For every identifier in a template, every usage/call to the template
 	// should contain that identifier. In other words, for every identifier
 	// in a template, if there is any usage/call to the template not containing the
 	// identifier then we should emit a warning or error.
 
-	res := checkResult{}
+	res := TemplateResult{}
 
 	for _, tident := range t {
-		for _, s := range tident.Idents {
+		for i, s := range tident.Idents {
+			start := len(res.Missing)
 
 			for _, u := range pkgUsages {
-				if containsString(u.Keys, s) {
+				if passes(u, tident.Idents, i) {
 					continue
+				} else if sup := suppressedBy(RuleMissingKey, tident.Suppression, u.Suppression); sup != nil {
+					used[sup] = true
 				} else {
-					res.Errs = append(res.Errs, MissingError{
+					res.Missing = append(res.Missing, MissingError{
 						Usage:         u,
 						TemplateIdent: tident,
 						MissingKey:    s,
+						index:         i,
+						Suggestions:   keySuggestions(tident.Idents, i, u),
 					})
 				}
 			}
 
+			if fix := keyFix(tident, i, pkgUsages, res.Missing[start:]); fix != nil {
+				for j := start; j < len(res.Missing); j++ {
+					res.Missing[j].Fix = fix
+				}
+			}
+
 		}
 	}
 
 	return res
 }
+
+// passes reports whether the usage passes the identifier at index i of
+// a field chain: a key passed by the usage, a method with a result of the
+// data, or, after the first one, a field, or a method with a result, of
+// the value of the identifiers before it, if its type is known. Fields of
+// the data are passed only if they are set, and map values are not
+// known, so they must be passed as keys.
+func passes(u Usage, idents []string, i int) bool {
+	if containsString(u.Keys, idents[i]) {
+		return true
+	}
+	typ, obj := visit.Field(visit.FieldChain(u.Data, idents[:i]), idents[i])
+	if i == 0 {
+		_, method := obj.(*types.Func)
+		return method && typ != nil
+	}
+	return obj != nil && typ != nil
+}
+
+// unusedKeys returns the keys passed by the usages that are not used in
+// the template. Suppressions that suppress an unused key are recorded
+// in used.
+func unusedKeys(pt parsedTemplate, pkgUsages []Usage, used map[*Suppression]bool) []UnusedKey {
+	if pt.opaque {
+		return nil
+	}
+	var ret []UnusedKey
+	for _, u := range pkgUsages {
+		for _, k := range u.Keys {
+			if pt.names[k] || containsIdent(pt.idents, k) {
+				continue
+			}
+			if s := u.Suppression; s.suppresses(RuleUnusedKey) {
+				used[s] = true
+				continue
+			}
+			ret = append(ret, UnusedKey{Usage: u, Key: k})
+		}
+	}
+	return ret
+}
+
+func containsIdent(idents []TemplateIdent, name string) bool {
+	for _, t := range idents {
+		if containsString(t.Idents, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// suppressedBy returns the first of the suppressions that suppresses
+// findings of the rule, or nil.
+func suppressedBy(rule string, sups ...*Suppression) *Suppression {
+	for _, s := range sups {
+		if s.suppresses(rule) {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package tmplcheck
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+)
+
+// The checkstyle output format is Checkstyle XML, for CI services that
+// show lint results. Findings are grouped by file: the template file for
+// findings in templates, and the go source file otherwise. As in the
+// gitlab and github formats, grouped findings are reported flat.
+
+type checkstyleResult struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr"`
+	Column   int    `xml:"column,attr,omitempty"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+// checkstyleVersion is the version of the Checkstyle format.
+const checkstyleVersion = "4.3"
+
+func writeCheckstyle(w io.Writer, res *Result) error {
+	base, err := outputBase(res)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string][]checkstyleError)
+	for i := range res.Templates {
+		r := &res.Templates[i]
+		for _, f := range r.flatFindings() {
+			tmpl, goFile := r.filePaths(base, f)
+			name, pos := tmpl, f.Template
+			if name == "" {
+				name, pos = goFile, f.Go
+			}
+			if name == "" {
+				name = r.displayName()
+			}
+			files[name] = append(files[name], checkstyleError{
+				Line:     pos.Line,
+				Column:   pos.Col,
+				Severity: f.Severity.String(),
+				Message:  f.Message,
+				Source:   "tmplcheck." + f.Rule.ID,
+			})
+		}
+	}
+
+	out := checkstyleResult{Version: checkstyleVersion}
+	for name, errs := range files {
+		sort.SliceStable(errs, func(i, j int) bool {
+			if errs[i].Line != errs[j].Line {
+				return errs[i].Line < errs[j].Line
+			}
+			return errs[i].Column < errs[j].Column
+		})
+		out.Files = append(out.Files, checkstyleFile{Name: name, Errors: errs})
+	}
+	sort.Slice(out.Files, func(i, j int) bool {
+		return out.Files[i].Name < out.Files[j].Name
+	})
+
+	return writeXML(w, out)
+}
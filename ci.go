@@ -0,0 +1,164 @@
+package tmplcheck
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The gitlab and github output formats show findings on merge requests
+// and pull requests. Paths are relative to Result.Base, which is the
+// root of the repository when run from the tmplcheck command. Each issue
+// or annotation has a single position, so grouped findings are reported
+// flat; see TemplateResult.flatFindings.
+
+// gitlabIssue is an issue in a GitLab Code Quality report.
+// See https://docs.gitlab.com/ee/ci/testing/code_quality.html.
+type gitlabIssue struct {
+	Description string         `json:"description"`
+	CheckName   string         `json:"check_name"`
+	Fingerprint string         `json:"fingerprint"`
+	Severity    string         `json:"severity"`
+	Location    gitlabLocation `json:"location"`
+}
+
+type gitlabLocation struct {
+	Path  string      `json:"path"`
+	Lines gitlabLines `json:"lines"`
+}
+
+type gitlabLines struct {
+	Begin int `json:"begin"`
+	End   int `json:"end,omitempty"`
+}
+
+// gitlabSeverity returns the Code Quality severity of the severity.
+func gitlabSeverity(s Severity) string {
+	switch s {
+	case SeverityError:
+		return "critical"
+	case SeverityWarning:
+		return "minor"
+	}
+	return "info"
+}
+
+func writeGitLab(w io.Writer, res *Result) error {
+	base, err := outputBase(res)
+	if err != nil {
+		return err
+	}
+
+	issues := []gitlabIssue{}
+	seen := make(map[string]int) // occurrences of fingerprints
+	for i := range res.Templates {
+		r := &res.Templates[i]
+		for _, f := range r.flatFindings() {
+			tmpl, goFile := r.filePaths(base, f)
+			loc := gitlabLocation{Path: tmpl, Lines: gitlabLines{f.Template.Line, f.Template.EndLine}}
+			description := f.Message
+			if tmpl == "" {
+				loc = gitlabLocation{Path: goFile, Lines: gitlabLines{Begin: f.Go.Line}}
+			} else if goFile != "" {
+				description += " (" + positionString(goFile, f.Go) + ")"
+			}
+			if loc.Path == "" {
+				loc.Path = r.displayName()
+			}
+			if loc.Lines.Begin == 0 {
+				loc.Lines.Begin = 1 // required
+			}
+
+			// Fingerprints must be unique in the report, but identical
+			// findings, such as a key missing twice on a line, have the
+			// same fingerprint.
+			fp := f.Fingerprint()
+			if n := seen[fp]; n > 0 {
+				seen[fp]++
+				fp = fingerprint(fp, strconv.Itoa(n))
+			} else {
+				seen[fp] = 1
+			}
+
+			issues = append(issues, gitlabIssue{
+				Description: description,
+				CheckName:   f.Rule.ID + " " + f.Rule.Name,
+				Fingerprint: fp,
+				Severity:    gitlabSeverity(f.Severity),
+				Location:    loc,
+			})
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(issues)
+}
+
+// githubCommand returns the GitHub Actions workflow command of the
+// severity. See https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions.
+func githubCommand(s Severity) string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "notice"
+}
+
+func writeGitHub(w io.Writer, res *Result) error {
+	base, err := outputBase(res)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for i := range res.Templates {
+		r := &res.Templates[i]
+		for _, f := range r.flatFindings() {
+			tmpl, goFile := r.filePaths(base, f)
+			file, pos := tmpl, f.Template
+			msg := f.Message
+			if tmpl == "" {
+				file, pos = goFile, f.Go
+			} else if goFile != "" {
+				msg += " (" + positionString(goFile, f.Go) + ")"
+			}
+
+			props := []string{"title=" + githubEscapeProperty(f.Rule.ID+" "+f.Rule.Name)}
+			if file != "" {
+				props = append(props, "file="+githubEscapeProperty(file))
+				if pos.Line > 0 {
+					props = append(props, "line="+strconv.Itoa(pos.Line))
+				}
+				if pos.Col > 0 {
+					props = append(props, "col="+strconv.Itoa(pos.Col))
+				}
+				if pos.EndLine > 0 {
+					props = append(props, "endLine="+strconv.Itoa(pos.EndLine), "endColumn="+strconv.Itoa(pos.EndCol))
+				}
+			}
+			fmt.Fprintf(bw, "::%s %s::%s\n", githubCommand(f.Severity), strings.Join(props, ","), githubEscapeData(msg))
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	githubDataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	githubPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// githubEscapeData escapes the message of a workflow command.
+func githubEscapeData(s string) string {
+	return githubDataEscaper.Replace(s)
+}
+
+// githubEscapeProperty escapes a property of a workflow command.
+func githubEscapeProperty(s string) string {
+	return githubPropertyEscaper.Replace(s)
+}
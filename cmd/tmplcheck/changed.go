@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-web-framework/tmplcheck"
+)
+
+// changedFiles returns the files changed in the working tree since the
+// merge base of the git revision and HEAD, including untracked files, by
+// absolute path with symbolic links resolved. For a base branch, these
+// are the changes of the current branch, and not those made to the base
+// branch since the current one was started.
+func changedFiles(rev string) (map[string]bool, error) {
+	top, err := git("", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = strings.TrimSpace(top)
+
+	base, err := git(top, "merge-base", rev, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	diff, err := git(top, "diff", "--name-only", "-z", strings.TrimSpace(base), "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(top, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]bool)
+	for _, name := range strings.Split(diff+untracked, "\x00") {
+		if name != "" {
+			ret[realPath(filepath.Join(top, name))] = true
+		}
+	}
+	return ret, nil
+}
+
+// repoRoot returns the root of the git repository of the working
+// directory, which paths are relative to in some output formats, or ""
+// if there is none.
+func repoRoot() string {
+	top, err := git("", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(top)
+}
+
+// git runs git with the arguments in dir, or in the working directory
+// if dir is empty, and returns its output.
+func git(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return stdout.String(), nil
+}
+
+// realPath returns the absolute path with symbolic links resolved. If
+// the file does not exist, for instance because it was deleted, those of
+// its closest parent directory that exists are resolved.
+func realPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rest := ""
+	for p := abs; ; {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(real, rest)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return abs
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// affects reports whether any of the changed files is a template in
+// cfg.Templates or a go file in the directory of one of the packages,
+// so that roots whose files did not change are not checked at all.
+func affects(cfg *tmplcheck.Config, changed map[string]bool) (bool, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return false, err
+	}
+	var dirs []string
+	for _, path := range cfg.ImportPaths() {
+		pkg, err := build.Import(path, wd, build.FindOnly)
+		if err != nil {
+			return false, err
+		}
+		dirs = append(dirs, realPath(pkg.Dir))
+	}
+	root := realPath(cfg.Templates)
+
+	for f := range changed {
+		if rel, err := filepath.Rel(root, f); err == nil && !escapes(rel) {
+			return true, nil
+		}
+		if strings.HasSuffix(f, ".go") && containsString(dirs, filepath.Dir(f)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// filterChanged removes the findings of the result that involve none of
+// the changed files: neither the template nor the go file of the call.
+// Results of templates that did not change and have no findings left
+// are removed too.
+func filterChanged(res *tmplcheck.Result, templates string, changed map[string]bool) {
+	root := realPath(templates)
+	paths := make(map[string]string) // memoizes realPath
+	involves := func(template string, u *tmplcheck.Usage) bool {
+		if template != "" && changed[filepath.Join(root, filepath.FromSlash(template))] {
+			return true
+		}
+		if u == nil {
+			return false
+		}
+		p, ok := paths[u.Filename]
+		if !ok {
+			p = realPath(u.Filename)
+			paths[u.Filename] = p
+		}
+		return changed[p]
+	}
+
+	results := res.Templates[:0]
+	for _, r := range res.Templates {
+		r.Filter(func(f tmplcheck.Finding) bool {
+			if f.Template.IsValid() {
+				return involves(r.Template, f.Usage)
+			}
+			return involves("", f.Usage)
+		})
+		if involves(r.Template, nil) || len(r.Findings()) != 0 {
+			results = append(results, r)
+		}
+	}
+	res.Templates = results
+}
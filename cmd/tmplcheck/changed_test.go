@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-web-framework/tmplcheck"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAffects(t *testing.T) {
+	Convey("affects", t, func() {
+		testdata := filepath.Join("..", "..", "testdata")
+		cfg := &tmplcheck.Config{
+			Package:   "github.com/go-web-framework/tmplcheck/testdata/src",
+			Templates: filepath.Join(testdata, "templates"),
+		}
+
+		for _, tt := range []struct {
+			changed []string
+			want    bool
+		}{
+			{nil, false},
+			{[]string{"templates/root.html"}, true},
+			{[]string{"templates/partials/nav.html"}, true},
+			{[]string{"src/hello.go"}, true},
+			{[]string{"README.md", "src/hello.go"}, true},
+			{[]string{"src/README.md"}, false},
+			{[]string{"suggest/src/main.go"}, false},
+			{[]string{"suggest/templates/page.html"}, false},
+			{[]string{"templates-parseerr/broken.html"}, false},
+		} {
+			changed := make(map[string]bool)
+			for _, f := range tt.changed {
+				changed[realPath(filepath.Join(testdata, filepath.FromSlash(f)))] = true
+			}
+			ok, err := affects(cfg, changed)
+			So(err, ShouldBeNil)
+			So(ok, ShouldEqual, tt.want)
+		}
+	})
+}
+
+func TestFilterChanged(t *testing.T) {
+	Convey("filterChanged", t, func() {
+		dir, err := ioutil.TempDir("", "tmplcheck")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		templates := filepath.Join(dir, "templates")
+		So(os.MkdirAll(templates, 0755), ShouldBeNil)
+		So(os.MkdirAll(filepath.Join(dir, "app"), 0755), ShouldBeNil)
+		mainGo := filepath.Join(dir, "app", "main.go")
+		otherGo := filepath.Join(dir, "app", "other.go")
+
+		usage := func(filename, template string) tmplcheck.Usage {
+			return tmplcheck.Usage{Path: "app/" + filepath.Base(filename), Filename: filename, Line: 1, Col: 1, Template: template}
+		}
+		missing := func(template, filename string) tmplcheck.MissingError {
+			return tmplcheck.MissingError{
+				Usage:         usage(filename, template),
+				TemplateIdent: tmplcheck.TemplateIdent{Path: template, Line: 1, Col: 1},
+				MissingKey:    "Title",
+			}
+		}
+		result := func() *tmplcheck.Result {
+			return &tmplcheck.Result{Templates: []tmplcheck.TemplateResult{
+				{Template: "a.html", Missing: []tmplcheck.MissingError{missing("a.html", mainGo)}},
+				{Template: "b.html", Missing: []tmplcheck.MissingError{missing("b.html", mainGo), missing("b.html", otherGo)}},
+				{Template: "sub/c.html"},
+				{Template: "d.html", Unknown: []tmplcheck.UnknownTemplate{{Usage: usage(otherGo, "d.html")}}},
+			}}
+		}
+
+		for _, tt := range []struct {
+			changed []string
+			want    []string // templates and their number of findings
+		}{
+			{nil, nil},
+			{[]string{"templates/a.html"}, []string{"a.html:1"}},
+			{[]string{"templates/sub/c.html"}, []string{"sub/c.html:0"}},
+			{[]string{"app/main.go"}, []string{"a.html:1", "b.html:1"}},
+			{[]string{"app/other.go"}, []string{"b.html:1", "d.html:1"}},
+			{[]string{"templates/b.html", "app/other.go"}, []string{"b.html:2", "d.html:1"}},
+			{[]string{"README.md"}, nil},
+		} {
+			changed := make(map[string]bool)
+			for _, f := range tt.changed {
+				changed[realPath(filepath.Join(dir, filepath.FromSlash(f)))] = true
+			}
+			res := result()
+			filterChanged(res, templates, changed)
+			var got []string
+			for i := range res.Templates {
+				r := &res.Templates[i]
+				got = append(got, fmt.Sprintf("%s:%d", r.Template, len(r.Findings())))
+			}
+			So(got, ShouldResemble, tt.want)
+		}
+	})
+}
+
+func TestChangedFiles(t *testing.T) {
+	Convey("changedFiles", t, func() {
+		dir, err := ioutil.TempDir("", "tmplcheck")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		wd, err := os.Getwd()
+		So(err, ShouldBeNil)
+		So(os.Chdir(dir), ShouldBeNil)
+		defer os.Chdir(wd)
+
+		run := func(args ...string) {
+			_, err := git(dir, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+			So(err, ShouldBeNil)
+		}
+		write := func(name, contents string) {
+			So(ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644), ShouldBeNil)
+		}
+
+		run("init", "-q")
+		write("base.html", "base")
+		write("main.html", "main")
+		run("add", ".")
+		run("commit", "-q", "-m", "base")
+		run("branch", "-M", "main")
+		run("checkout", "-q", "-b", "feature")
+		write("feature.html", "feature")
+		run("add", ".")
+		run("commit", "-q", "-m", "feature")
+
+		// main moves on after the branch was started.
+		run("checkout", "-q", "main")
+		write("main.html", "main changed")
+		run("commit", "-q", "-am", "main")
+		run("checkout", "-q", "feature")
+
+		write("base.html", "uncommitted")
+		write("untracked.html", "untracked")
+
+		changed, err := changedFiles("main")
+		So(err, ShouldBeNil)
+		var names []string
+		for f := range changed {
+			names = append(names, filepath.Base(f))
+		}
+		So(names, ShouldHaveLength, 3)
+		So(names, ShouldContain, "feature.html")
+		So(names, ShouldContain, "base.html")
+		So(names, ShouldContain, "untracked.html")
+	})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-web-framework/tmplcheck"
+)
+
+// configFileName is the name of the configuration file, which is looked
+// up in the working directory and its parents unless -config is set.
+const configFileName = ".tmplcheck.json"
+
+// configFile is the contents of the configuration file. Paths are
+// relative to the directory of the file.
+//
+//	{
+//		"packages": ["example.com/app/..."],
+//		"roots": [
+//			{"path": "templates", "include": ["*.html"]},
+//			{"path": "admin/templates", "packages": ["example.com/admin"], "ldelim": "[[", "rdelim": "]]"}
+//		],
+//		"ignore": ["partials/_*.tmpl"],
+//		"severity": {"TC007": "off", "unused-key": "warning"},
+//		"overrides": [
+//			{"dir": "templates/legacy", "severity": {"missing-key": "warning"}}
+//		]
+//	}
+type configFile struct {
+	Packages []string `json:"packages"` // import paths or patterns, for roots without packages
+	Roots    []root   `json:"roots"`
+
+	// Ignore are glob patterns of template files not to check, relative
+	// to each root.
+	Ignore []string `json:"ignore"`
+
+	Severity  severities `json:"severity"`
+	Overrides []override `json:"overrides"`
+}
+
+// root is a templates directory.
+type root struct {
+	Path     string   `json:"path"`
+	Packages []string `json:"packages"` // packages that execute the templates
+	Ldelim   string   `json:"ldelim"`
+	Rdelim   string   `json:"rdelim"`
+	Include  []string `json:"include"` // glob patterns of template files to check
+	Ignore   []string `json:"ignore"`  // glob patterns of template files not to check
+}
+
+// override sets the severity of rules for the templates in a directory.
+type override struct {
+	Dir      string     `json:"dir"`
+	Severity severities `json:"severity"`
+}
+
+// severities are severities by rule ID or name. Unlike a map of
+// tmplcheck.Severity, a null or missing severity is an error rather than
+// the zero value, which would turn the rule off.
+type severities map[string]tmplcheck.Severity
+
+func (s *severities) UnmarshalJSON(b []byte) error {
+	var names map[string]*string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+	rules := make([]string, 0, len(names))
+	for rule := range names {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules) // for the same error each time
+
+	*s = make(severities)
+	for _, rule := range rules {
+		if names[rule] == nil {
+			return fmt.Errorf("missing severity of rule %q", rule)
+		}
+		sev, err := tmplcheck.ParseSeverity(*names[rule])
+		if err != nil {
+			return fmt.Errorf("severity of rule %q: %v", rule, err)
+		}
+		(*s)[rule] = sev
+	}
+	return nil
+}
+
+// findConfigFile returns the path of the configuration file in the
+// working directory or its closest parent, or "" if there is none.
+func findConfigFile() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		p := filepath.Join(dir, configFileName)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// readConfigFile reads the configuration file at path and makes its
+// paths relative to the working directory, or absolute.
+func readConfigFile(path string) (*configFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c configFile
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range c.Roots {
+		if c.Roots[i].Path == "" {
+			return nil, fmt.Errorf("%s: root without path", path)
+		}
+		c.Roots[i].Path = resolve(dir, c.Roots[i].Path)
+	}
+	for i := range c.Overrides {
+		if c.Overrides[i].Dir == "" {
+			return nil, fmt.Errorf("%s: override without dir", path)
+		}
+		c.Overrides[i].Dir = resolve(dir, c.Overrides[i].Dir)
+	}
+	return &c, nil
+}
+
+func resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// configs returns the tmplcheck configuration for each root of the
+// configuration file, or for the root given by -t. Flags that are set
+// take precedence over the file: -t replaces the roots, and -p, -ldelim
+// and -rdelim apply to all roots.
+func (c *configFile) configs(set map[string]bool) ([]*tmplcheck.Config, error) {
+	roots := c.Roots
+	if set["t"] {
+		roots = []root{{Path: templatesPath}}
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("-t is required")
+	}
+
+	var ret []*tmplcheck.Config
+	for _, r := range roots {
+		cfg := &tmplcheck.Config{
+			Packages:   r.Packages,
+			Templates:  r.Path,
+			LeftDelim:  r.Ldelim,
+			RightDelim: r.Rdelim,
+			Include:    r.Include,
+			Ignore:     append(append([]string(nil), c.Ignore...), r.Ignore...),
+			Severity:   c.Severity,
+		}
+		if len(cfg.Packages) == 0 {
+			cfg.Packages = c.Packages
+		}
+		if set["p"] {
+			cfg.Packages = []string{packagePath}
+		}
+		if len(cfg.Packages) == 0 {
+			return nil, errors.New("-p is required")
+		}
+		if set["ldelim"] || cfg.LeftDelim == "" {
+			cfg.LeftDelim = leftDelim
+		}
+		if set["rdelim"] || cfg.RightDelim == "" {
+			cfg.RightDelim = rightDelim
+		}
+
+		for _, o := range c.Overrides {
+			if dir, ok := overrideDir(r.Path, o.Dir); ok {
+				cfg.Overrides = append(cfg.Overrides, tmplcheck.Override{Dir: dir, Severity: o.Severity})
+			}
+		}
+		ret = append(ret, cfg)
+	}
+	return ret, nil
+}
+
+// overrideDir returns the directory of an override relative to the
+// root, and whether the override applies to templates in the root. It
+// applies to the whole root if the root is in the directory.
+func overrideDir(root, dir string) (string, bool) {
+	if rel, err := filepath.Rel(root, dir); err == nil && !escapes(rel) {
+		return rel, true
+	}
+	if rel, err := filepath.Rel(dir, root); err == nil && !escapes(rel) {
+		return ".", true
+	}
+	return "", false
+}
+
+// escapes reports whether the relative path is outside of its base.
+func escapes(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
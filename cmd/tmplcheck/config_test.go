@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-web-framework/tmplcheck"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	Convey("readConfigFile", t, func() {
+		dir, err := ioutil.TempDir("", "tmplcheck")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		read := func(contents string) (*configFile, error) {
+			path := filepath.Join(dir, configFileName)
+			So(ioutil.WriteFile(path, []byte(contents), 0644), ShouldBeNil)
+			return readConfigFile(path)
+		}
+
+		Convey("reads severities and makes paths relative to the file", func() {
+			c, err := read(`{
+				"roots": [{"path": "templates"}],
+				"severity": {"TC007": "off", "unused-key": "warning"},
+				"overrides": [{"dir": "templates/legacy", "severity": {"missing-key": "info"}}]
+			}`)
+			So(err, ShouldBeNil)
+			So(c.Roots[0].Path, ShouldEqual, filepath.Join(dir, "templates"))
+			So(map[string]tmplcheck.Severity(c.Severity), ShouldResemble, map[string]tmplcheck.Severity{
+				"TC007":      tmplcheck.SeverityOff,
+				"unused-key": tmplcheck.SeverityWarning,
+			})
+			So(c.Overrides[0].Dir, ShouldEqual, filepath.Join(dir, "templates", "legacy"))
+			So(c.Overrides[0].Severity["missing-key"], ShouldEqual, tmplcheck.SeverityInfo)
+		})
+
+		Convey("rejects unknown and missing severities", func() {
+			for _, contents := range []string{
+				`{"severity": {"TC001": "warn"}}`,
+				`{"severity": {"TC001": ""}}`,
+				`{"severity": {"TC001": null}}`,
+				`{"severity": {"TC001": 1}}`,
+				`{"overrides": [{"dir": "templates", "severity": {"TC001": null}}]}`,
+			} {
+				_, err := read(contents)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "TC001")
+			}
+		})
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-web-framework/tmplcheck"
+)
+
+// printFixes prints the fixes of the result as a unified diff.
+func printFixes(res *tmplcheck.Result) error {
+	return eachFix(res, func(path string, old, new []byte) error {
+		return tmplcheck.WriteDiff(os.Stdout, filepath.ToSlash(displayPath(path)), old, new)
+	})
+}
+
+// applyFixes writes the fixes of the result to the template files, and
+// removes the fixed findings, and those about keys the fixes now use,
+// from the result.
+func applyFixes(res *tmplcheck.Result) error {
+	files := 0
+	err := eachFix(res, func(path string, old, new []byte) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		files++
+		return ioutil.WriteFile(path, new, info.Mode())
+	})
+	if err != nil {
+		return err
+	}
+	if n := removeFixed(res); n != 0 {
+		fmt.Fprintf(os.Stderr, "fixed %d findings in %d files\n", n, files)
+	}
+	return nil
+}
+
+// removeFixed removes the findings that the fixes of the result fix,
+// and those about keys the fixes use, from the result, and returns the
+// number of fixed findings.
+func removeFixed(res *tmplcheck.Result) int {
+	// Keys that the template uses once fixed are no longer unused.
+	n := 0
+	for i := range res.Templates {
+		used := make(map[string]bool)
+		for _, e := range res.Templates[i].Missing {
+			if e.Fix != nil && e.Fix.Pos == int(e.TemplateIdent.Pos)+1 {
+				used[e.Fix.New] = true
+			}
+		}
+		res.Templates[i].Filter(func(f tmplcheck.Finding) bool {
+			switch e := f.Value.(type) {
+			case tmplcheck.MissingError:
+				if e.Fix != nil {
+					n++
+					return false
+				}
+			case tmplcheck.UnusedKey:
+				return !used[e.Key]
+			}
+			return true
+		})
+	}
+	return n
+}
+
+// eachFix calls fn with the old and new contents of each template file
+// that has fixes, in order of path.
+func eachFix(res *tmplcheck.Result, fn func(path string, old, new []byte) error) error {
+	fixes := res.Fixes()
+	paths := make([]string, 0, len(fixes))
+	for path := range fixes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		old, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		new, err := tmplcheck.ApplyEdits(old, fixes[path])
+		if err != nil {
+			return err
+		}
+		if err := fn(path, old, new); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+/*
+Command tmplcheck checks that templates do not use keys not passed in
+from go source code in Execute calls.
+
+Usage:
+
+	tmplcheck -p <import path of go code> -t <path to templates> [-format <format> | -f <template>] [-watch]
+	          [-baseline <file> | -write-baseline <file>] [-changed-since <git revision>]
+	          [-fail-on <error|warning|info|off>] [-max-warnings <n>] [-context <n>] [-flat]
+	          [-fix | -diff]
+	tmplcheck lsp -p <import path of go code> -t <path to templates>
+	tmplcheck explain [rule]
+
+With -write-baseline, the findings are written to the baseline file
+instead of being printed. With -baseline, the findings in the baseline
+file are not printed, and the entries of the file whose findings no
+longer occur are listed on stderr, so that they can be removed.
+
+With -changed-since, only findings involving templates or go files
+changed since the merge base of the git revision and HEAD, or not
+committed, are printed. All templates and packages are still checked,
+since a change to a go file may cause findings in a template that did
+not change; templates directories in which neither the templates nor
+the go files of the packages changed are skipped.
+
+The plain output format has a line per finding, such as
+
+	templates/page.html:5:14: "Title" is missing from the data passed by set.Execute (main.go:20:2) [TC001]
+
+colored if stdout is a terminal and NO_COLOR is not set. With -context n,
+each finding is followed by its line of the template with carets under
+the key or field chain and n lines before and after it, and by the line
+of the Execute call.
+
+Findings are sorted by template and position. A key missing from the
+data of an Execute call is reported once per template and field chain,
+such as .User.Name, with the other uses of the chain and the other calls
+that do not pass the key listed under it; with -flat, it is reported for
+each use and each call.
+The messages of missing keys and unknown templates that may be
+misspellings of keys, fields or templates that exist end with
+suggestions, such as: did you mean "Title"?
+
+With -fix, missing keys whose suggestion is almost certainly meant, as
+it differs only in case or by one character or transposition, for every
+Execute call of the template, and which every call passes, as a key or
+as a field of the keys before it, such as Name in .Author.Name, are
+replaced in the template files, and the other findings are reported.
+Only the bytes of the keys are changed, so delimiters, trim markers and
+spacing are kept. With -diff, the changes are printed as a unified diff
+instead, the files are not changed, and the other findings are reported
+to stderr; the exit code is that of the findings before the changes.
+
+With -f, each finding is printed by executing the text/template with a
+tmplcheck.FindingData, followed by a newline, as with go list -f:
+
+	tmplcheck -f '{{.Template.File}}:{{.Template.Line}} {{.Key}}' ...
+
+The output formats are plain, json, sarif, junit, checkstyle, html,
+gitlab and github.
+With -format sarif, the findings are printed as a SARIF 2.1.0 log for
+code scanning services, with the Execute call of findings in templates
+as a related location. With -format junit, each template is a test suite
+with a failing test case for each finding, and with -format checkstyle,
+findings are grouped by file. With -format html, a single HTML page is
+printed, which needs no other files, with counts of findings by rule and
+by directory and the source of the templates with findings highlighted.
+With -format gitlab, the findings are printed as a GitLab Code Quality
+report, and with -format github, as GitHub Actions workflow commands
+such as ::error file=templates/page.html,line=5,col=13::message, so that
+they are shown on merge requests and pull requests. Paths in these
+formats are relative to the root of the git repository, or to the
+working directory outside of a repository. In these formats and
+checkstyle, a missing key is reported for each use and each call, as
+with -flat, since they have one position for each finding.
+
+The exit code is 1 if there are findings with the -fail-on severity or
+a higher one, or more warnings than -max-warnings, and 2 if the check
+could not be performed.
+
+The explain command describes the rule with the ID, such as TC001, or
+name, with examples, or lists the rules if none is given. Findings are
+printed with the ID of their rule.
+
+The lsp command runs a language server over stdin and stdout. See package
+github.com/go-web-framework/tmplcheck/lsp.
+
+See package github.com/go-web-framework/tmplcheck for the library.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-web-framework/tmplcheck"
+	"github.com/go-web-framework/tmplcheck/lsp"
+)
+
+var (
+	templatesPath string
+	packagePath   string
+	leftDelim     string
+	rightDelim    string
+	outputFormat  string
+	watchMode     bool
+	configPath    string
+	baselinePath  string
+	writeBaseline string
+	changedSince  string
+	failOn        string
+	maxWarnings   int
+	contextLines  int
+	formatText    string
+	flat          bool
+	fixMode       bool
+	diffMode      bool
+
+	// reporter writes the results, in outputFormat or with the
+	// formatText template. It is set by checkArgs.
+	reporter tmplcheck.Reporter
+)
+
+// Exit codes.
+const (
+	exitFindings = 1 // findings above the thresholds
+	exitFailure  = 2 // the check could not be performed
+)
+
+func main() {
+	flag.StringVar(&templatesPath, "t", "", "path to templates directory")
+	flag.StringVar(&packagePath, "p", "", "package import path or pattern")
+	flag.StringVar(&leftDelim, "ldelim", "{{", "left delimiter in templates")
+	flag.StringVar(&rightDelim, "rdelim", "}}", "right delimiter in templates")
+	flag.StringVar(&outputFormat, "format", "plain", "output format ("+strings.Join(tmplcheck.Formats, ",")+")")
+	flag.BoolVar(&watchMode, "watch", false, "check again when templates or go files change, reporting new findings")
+	flag.StringVar(&configPath, "config", "", "path to configuration file (default "+configFileName+" in the working directory or a parent)")
+	flag.StringVar(&baselinePath, "baseline", "", "path to baseline file; findings in it are not reported")
+	flag.StringVar(&writeBaseline, "write-baseline", "", "write the findings to the baseline file at path instead of printing them")
+	flag.StringVar(&changedSince, "changed-since", "", "only report findings involving files changed since the git revision")
+	flag.StringVar(&failOn, "fail-on", "error", "exit with code 1 if there are findings with this severity or higher (error, warning, info or off)")
+	flag.IntVar(&maxWarnings, "max-warnings", -1, "exit with code 1 if there are more warnings than this; negative for no limit")
+	flag.IntVar(&contextLines, "context", 0, "print the source of each finding with `n` lines of context (plain format)")
+	flag.StringVar(&formatText, "f", "", "format each finding with the text/template; see FindingData in package tmplcheck")
+	flag.BoolVar(&flat, "flat", false, "report a missing key for each use and call instead of once per template")
+	flag.BoolVar(&fixMode, "fix", false, "fix misspelled keys in templates that have a single likely correction, and report the other findings")
+	flag.BoolVar(&diffMode, "diff", false, "print the fixes of -fix as a unified diff instead of applying them")
+	flag.Parse()
+
+	// Flags may also follow the command.
+	command := flag.Arg(0)
+	if command != "" {
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+
+	if command == "explain" {
+		explain(flag.Args())
+		return
+	}
+
+	cfgs := checkArgs()
+
+	switch command {
+	case "":
+	case "lsp":
+		if len(cfgs) != 1 {
+			exitErr("lsp supports a single templates directory")
+		}
+		if err := lsp.Serve(context.Background(), os.Stdin, os.Stdout, cfgs[0]); err != nil {
+			exitErr(err)
+		}
+		return
+	default:
+		exitErr(`unknown command: "` + command + `"`)
+	}
+
+	var base *tmplcheck.Baseline
+	if baselinePath != "" {
+		var err error
+		base, err = tmplcheck.ReadBaseline(baselinePath)
+		if err != nil {
+			exitErr(err)
+		}
+	}
+
+	if watchMode {
+		if len(cfgs) != 1 {
+			exitErr("-watch supports a single templates directory")
+		}
+		watch(cfgs[0], base)
+		return
+	}
+
+	var changed map[string]bool
+	if changedSince != "" {
+		var err error
+		changed, err = changedFiles(changedSince)
+		if err != nil {
+			exitErr(err)
+		}
+	}
+
+	res := &tmplcheck.Result{Base: repoRoot()}
+	for _, cfg := range cfgs {
+		if changed != nil {
+			ok, err := affects(cfg, changed)
+			if err != nil {
+				exitErr(err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		r, err := tmplcheck.Check(context.Background(), cfg)
+		if err != nil {
+			exitErr(err)
+		}
+		if changed != nil {
+			filterChanged(r, cfg.Templates, changed)
+		}
+		if len(cfgs) > 1 {
+			root := displayPath(cfg.Templates)
+			for i := range r.Templates {
+				r.Templates[i].Root = root
+			}
+		}
+		res.Templates = append(res.Templates, r.Templates...)
+	}
+	if len(cfgs) > 1 {
+		filterUnknown(res, cfgs)
+		res.Sort()
+	}
+
+	if writeBaseline != "" {
+		b := tmplcheck.NewBaseline(res)
+		if err := b.WriteFile(writeBaseline); err != nil {
+			exitErr(err)
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d findings to %s\n", countEntries(b.Findings), writeBaseline)
+		return
+	}
+
+	var fixed []tmplcheck.BaselineEntry
+	if base != nil {
+		fixed = base.Filter(res)
+	}
+
+	if fixMode {
+		if err := applyFixes(res); err != nil {
+			exitErr(err)
+		}
+	}
+
+	// The thresholds apply to the findings before they are grouped, so
+	// that they do not depend on -flat. With -diff, the files are not
+	// changed, so they apply to the findings that the diff fixes too.
+	fail := failed(res)
+	out := os.Stdout
+	if diffMode {
+		if err := printFixes(res); err != nil {
+			exitErr(err)
+		}
+		if n := removeFixed(res); n != 0 {
+			fmt.Fprintf(os.Stderr, "the diff fixes %d findings\n", n)
+		}
+		out = os.Stderr // stdout is the diff
+	}
+	if !flat {
+		res.Group()
+	}
+	if err := reporter.Report(out, res); err != nil {
+		exitErr(err)
+	}
+
+	if len(fixed) != 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d findings no longer occur and can be removed:\n", baselinePath, countEntries(fixed))
+		for _, e := range fixed {
+			name := e.Template
+			if name == "" {
+				name = "<unknown template>"
+			}
+			fmt.Fprintf(os.Stderr, "\t%s: %s: %s (x%d)\n", name, e.Rule, e.Message, e.Count)
+		}
+	}
+
+	if fail {
+		os.Exit(exitFindings)
+	}
+}
+
+// failed reports whether the result has findings above the thresholds
+// set by -fail-on and -max-warnings.
+func failed(res *tmplcheck.Result) bool {
+	threshold, _ := tmplcheck.ParseSeverity(failOn) // checked by checkArgs
+	if threshold != tmplcheck.SeverityOff {
+		for sev := threshold; sev <= tmplcheck.SeverityError; sev++ {
+			if res.Count(sev) != 0 {
+				return true
+			}
+		}
+	}
+	return maxWarnings >= 0 && res.Count(tmplcheck.SeverityWarning) > maxWarnings
+}
+
+// filterUnknown removes the unknown-template findings of templates that
+// exist in the templates directory of any of the configurations, since
+// packages may execute templates of several roots.
+func filterUnknown(res *tmplcheck.Result, cfgs []*tmplcheck.Config) {
+	for i := range res.Templates {
+		res.Templates[i].Filter(func(f tmplcheck.Finding) bool {
+			if f.Rule.ID != tmplcheck.RuleUnknownTemplate {
+				return true
+			}
+			for _, cfg := range cfgs {
+				if _, err := os.Stat(filepath.Join(cfg.Templates, filepath.FromSlash(f.Usage.Template))); err == nil {
+					return false
+				}
+			}
+			return true
+		})
+	}
+}
+
+// countEntries returns the number of findings in the baseline entries.
+func countEntries(entries []tmplcheck.BaselineEntry) int {
+	n := 0
+	for _, e := range entries {
+		n += e.Count
+	}
+	return n
+}
+
+// checkArgs checks the arguments and returns the configuration for each
+// templates directory, from the flags and the configuration file.
+func checkArgs() []*tmplcheck.Config {
+	if !containsString(tmplcheck.Formats, outputFormat) {
+		exitErr(`unsupported output format: "` + outputFormat + `"`)
+	}
+	if _, err := tmplcheck.ParseSeverity(failOn); err != nil {
+		exitErr(`unsupported -fail-on severity: "` + failOn + `"`)
+	}
+	if contextLines < 0 {
+		exitErr("-context must not be negative")
+	}
+	if baselinePath != "" && writeBaseline != "" {
+		exitErr("-baseline and -write-baseline are mutually exclusive")
+	}
+	if writeBaseline != "" && watchMode {
+		exitErr("-write-baseline cannot be used with -watch")
+	}
+	if changedSince != "" && (watchMode || writeBaseline != "") {
+		exitErr("-changed-since cannot be used with -watch or -write-baseline")
+	}
+	if fixMode && diffMode {
+		exitErr("-fix and -diff are mutually exclusive")
+	}
+	if (fixMode || diffMode) && (watchMode || writeBaseline != "") {
+		exitErr("-fix and -diff cannot be used with -watch or -write-baseline")
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	tmplcheck.RegisterReporter("plain", &tmplcheck.PlainReporter{
+		Color:    useColor(os.Stdout),
+		Snippets: set["context"],
+		Context:  contextLines,
+	})
+	if set["f"] {
+		if set["format"] {
+			exitErr("-f and -format are mutually exclusive")
+		}
+		var err error
+		reporter, err = tmplcheck.NewTemplateReporter(formatText)
+		if err != nil {
+			exitErr(err)
+		}
+	} else {
+		reporter = tmplcheck.ReporterFunc(func(w io.Writer, res *tmplcheck.Result) error {
+			return tmplcheck.Output(w, outputFormat, res)
+		})
+	}
+
+	path := configPath
+	if path == "" {
+		var err error
+		path, err = findConfigFile()
+		if err != nil {
+			exitErr(err)
+		}
+	}
+	file := &configFile{}
+	if path != "" {
+		var err error
+		file, err = readConfigFile(path)
+		if err != nil {
+			exitErr(err)
+		}
+	}
+
+	cfgs, err := file.configs(set)
+	if err != nil {
+		exitErr(err)
+	}
+	return cfgs
+}
+
+// explain prints the documentation of the rules given by ID or name, or
+// the list of rules.
+func explain(args []string) {
+	if len(args) == 0 {
+		for _, r := range tmplcheck.Rules {
+			fmt.Printf("%s  %-18s  %-7s  %s\n", r.ID, r.Name, r.Default, r.Summary)
+		}
+		return
+	}
+	for i, arg := range args {
+		r := tmplcheck.LookupRule(arg)
+		if r == nil {
+			exitErr(`unknown rule: "` + arg + `"`)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(r.Explain())
+	}
+}
+
+// useColor reports whether output to the file should be colored: if it
+// is a terminal, unless the NO_COLOR environment variable is set.
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// displayPath returns path relative to the working directory if it is
+// in it.
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, abs); err == nil && !escapes(rel) {
+		return rel
+	}
+	return path
+}
+
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func exitErr(v interface{}) {
+	fmt.Fprintln(os.Stderr, v)
+	os.Exit(exitFailure)
+}
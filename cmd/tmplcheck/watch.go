@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-web-framework/tmplcheck"
+)
+
+// pollInterval is how often files are checked for changes in watch mode.
+const pollInterval = 500 * time.Millisecond
+
+// watch reports the result of checking, then checks again whenever files
+// change and reports the findings that are new, in the output format,
+// until interrupted. The number of new and fixed findings and the fixed
+// findings are printed to stderr, so that stdout has only reports.
+// Findings in the baseline, if not nil, are not reported.
+func watch(cfg *tmplcheck.Config, base *tmplcheck.Baseline) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var prev map[string]bool
+	root := repoRoot()
+	err := tmplcheck.Watch(ctx, cfg, pollInterval, func(res *tmplcheck.Result, err error) error {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil
+		}
+		res.Base = root
+		if base != nil {
+			base.Filter(res)
+		}
+
+		cur := findings(res)
+		if prev != nil {
+			removed := fixedFindings(prev, cur)
+			for i := range res.Templates {
+				res.Templates[i].Filter(func(f tmplcheck.Finding) bool {
+					return !prev[findingKey(&res.Templates[i], f)]
+				})
+			}
+			added := len(res.Findings())
+			if added == 0 && len(removed) == 0 {
+				prev = cur
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "\n%s: %d new, %d fixed\n", time.Now().Format("15:04:05"), added, len(removed))
+			for _, f := range removed {
+				fmt.Fprintln(os.Stderr, "fixed:", f)
+			}
+			if added == 0 {
+				prev = cur
+				return nil
+			}
+		}
+		prev = cur
+
+		if !flat {
+			res.Group()
+		}
+		return reporter.Report(os.Stdout, res)
+	})
+	if err != nil && err != ctx.Err() {
+		exitErr(err)
+	}
+}
+
+// findings returns the keys of the findings in the result, which should
+// not be grouped, so that each key is a finding.
+func findings(res *tmplcheck.Result) map[string]bool {
+	ret := make(map[string]bool)
+	for i := range res.Templates {
+		r := &res.Templates[i]
+		for _, f := range r.Findings() {
+			ret[findingKey(r, f)] = true
+		}
+	}
+	return ret
+}
+
+// findingKey returns a line that identifies the finding between checks,
+// as in the plain output format: its positions, message and rule.
+func findingKey(r *tmplcheck.TemplateResult, f tmplcheck.Finding) string {
+	var tmpl, call string
+	if f.Template.IsValid() {
+		path := filepath.ToSlash(displayPath(filepath.Join(r.Dir, f.Template.Path)))
+		tmpl = fmt.Sprintf("%s:%d:%d", path, f.Template.Line, f.Template.Col)
+	}
+	if f.Go.IsValid() && f.Usage != nil {
+		call = fmt.Sprintf("%s:%d:%d", filepath.ToSlash(displayPath(f.Usage.Filename)), f.Go.Line, f.Go.Col)
+	}
+	switch {
+	case tmpl != "" && call != "":
+		return fmt.Sprintf("%s: %s (%s) [%s]", tmpl, f.Message, call, f.Rule.ID)
+	case tmpl != "":
+		return fmt.Sprintf("%s: %s [%s]", tmpl, f.Message, f.Rule.ID)
+	case call != "":
+		return fmt.Sprintf("%s: %s [%s]", call, f.Message, f.Rule.ID)
+	}
+	return fmt.Sprintf("%s: %s [%s]", filepath.ToSlash(filepath.Join(r.Root, r.Template)), f.Message, f.Rule.ID)
+}
+
+// fixedFindings returns the sorted keys in prev that are not in cur.
+func fixedFindings(prev, cur map[string]bool) []string {
+	var ret []string
+	for k := range prev {
+		if !cur[k] {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,20 @@
+/*
+Command tmplcheckvet runs the tmplcheck analyzer on packages. It can be
+run by itself or by go vet:
+
+	tmplcheckvet -templates=templates ./...
+	go vet -vettool=$(which tmplcheckvet) -templates=templates ./...
+
+See package github.com/go-web-framework/tmplcheck/analyzer.
+*/
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"github.com/go-web-framework/tmplcheck/analyzer"
+)
+
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
+}
@@ -0,0 +1,162 @@
+package tmplcheck
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around changes in
+// unified diffs.
+const diffContext = 3
+
+// WriteDiff writes the differences between the old and new contents of
+// the file at path as a unified diff, as printed by diff -u, with the
+// file names a/path and b/path, or path if it is absolute. Nothing is
+// written if they are equal.
+func WriteDiff(w io.Writer, path string, old, new []byte) error {
+	a, b := splitLines(old), splitLines(new)
+	ops := diffLines(a, b)
+
+	var buf bytes.Buffer
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk is the changes up to the next unchanged run longer than
+		// twice the context, with the context around them.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end += diffContext
+				if end > run {
+					end = run
+				}
+				break
+			}
+			end = run
+		}
+		writeHunk(&buf, ops[start:end])
+		i = end
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	from, to := "a/"+path, "b/"+path
+	if strings.HasPrefix(path, "/") {
+		from, to = path, path
+	}
+	_, err := fmt.Fprintf(w, "--- %s\n+++ %s\n%s", from, to, buf.Bytes())
+	return err
+}
+
+// diffOp is a line of a diff: kind is ' ' for unchanged lines, '-' for
+// deleted lines and '+' for inserted lines. aLine and bLine are the
+// 0-based indexes of the line in the old and new contents, or of the
+// next line for lines that are not in them.
+type diffOp struct {
+	kind         byte
+	text         string
+	aLine, bLine int
+}
+
+func writeHunk(w *bytes.Buffer, ops []diffOp) {
+	var aLen, bLen int
+	for _, op := range ops {
+		if op.kind != '+' {
+			aLen++
+		}
+		if op.kind != '-' {
+			bLen++
+		}
+	}
+	fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(ops[0].aLine, aLen), hunkRange(ops[0].bLine, bLen))
+	for _, op := range ops {
+		w.WriteByte(op.kind)
+		w.WriteString(op.text)
+		if !strings.HasSuffix(op.text, "\n") {
+			w.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange returns the range of a hunk header, such as 3,4. Empty
+// ranges start at the line before them.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// splitLines splits b after each newline.
+func splitLines(b []byte) []string {
+	var ret []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		if i == 0 {
+			i = len(b)
+		}
+		ret = append(ret, string(b[:i]))
+		b = b[i:]
+	}
+	return ret
+}
+
+// diffLines returns the operations that turn a into b, from a longest
+// common subsequence of lines. Templates are small enough for its
+// quadratic cost.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of a longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
@@ -1,4 +1,8 @@
 /*
-Tmplcheck performs type checking between templates and go source files.
+Package tmplcheck performs type checking between templates and go source files.
+
+It checks that templates do not use keys that are not passed in from go
+source code in Execute calls. The tmplcheck command in cmd/tmplcheck is a
+thin wrapper around Check.
 */
-package main
+package tmplcheck
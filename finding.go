@@ -0,0 +1,259 @@
+package tmplcheck
+
+// Position is a position in a template or in a go source file.
+type Position struct {
+	Path string // relative path of template file, or path of go source file
+	Line int    // 0 if unknown
+	Col  int    // in characters; 0 if unknown
+
+	// EndLine and EndCol are the end of the range starting at the
+	// position, with EndCol after its last character, or 0 if unknown.
+	EndLine int
+	EndCol  int
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool {
+	return p.Path != ""
+}
+
+// Finding is a finding of any rule, for code that handles all rules
+// alike, such as output formats. Each field of a TemplateResult holds
+// the findings of one rule with details specific to the rule; Findings
+// returns them as Findings.
+type Finding struct {
+	Rule     *Rule
+	Severity Severity
+	Message  string // description, without positions
+
+	Template Position // in the template; invalid if none
+	Go       Position // in go source; invalid if none
+	Usage    *Usage   // the Execute call involved, if any
+
+	// OtherTemplate and OtherUsages are the other positions in the
+	// template and the other Execute calls of a finding grouped by
+	// TemplateResult.Group.
+	OtherTemplate []Position
+	OtherUsages   []Usage
+
+	// Suggestions are the names that a missing key or unknown template
+	// may be a misspelling of, closest first.
+	Suggestions []string
+
+	// Value is the finding as it is in the TemplateResult: a
+	// MissingError, ParseError, UnverifiableUsage, UnusedSuppression,
+	// UnusedKey, UnknownTemplate, UnexportedField or FuncArgsError.
+	Value interface{ Fingerprint() string }
+}
+
+// Fingerprint returns an identifier of the finding that is stable
+// across runs. See Baseline.
+func (f Finding) Fingerprint() string {
+	return f.Value.Fingerprint()
+}
+
+func usagePosition(u Usage) Position {
+	return Position{Path: u.Path, Line: u.Line, Col: u.Col}
+}
+
+func identPosition(t TemplateIdent) Position {
+	return Position{Path: t.Path, Line: t.Line, Col: t.Col, EndLine: t.EndLine, EndCol: t.EndCol}
+}
+
+// Findings returns the findings of the result, in the order of the
+// fields of TemplateResult.
+func (r *TemplateResult) Findings() []Finding {
+	var ret []Finding
+	r.Filter(func(f Finding) bool {
+		ret = append(ret, f)
+		return true
+	})
+	return ret
+}
+
+// Findings returns the findings of all templates.
+func (r *Result) Findings() []Finding {
+	var ret []Finding
+	for i := range r.Templates {
+		ret = append(ret, r.Templates[i].Findings()...)
+	}
+	return ret
+}
+
+// Filter calls keep for each finding of the result and removes the
+// findings for which it returns false. The findings that are kept are
+// copied to new slices, so that results sharing slices with r are not
+// changed.
+func (r *TemplateResult) Filter(keep func(Finding) bool) {
+	if r.ParseErr != nil && !keep(r.ParseErr.finding()) {
+		r.ParseErr = nil
+	}
+
+	var missing []MissingError
+	for _, e := range r.Missing {
+		if keep(e.finding()) {
+			missing = append(missing, e)
+		}
+	}
+	r.Missing = missing
+
+	var unusedKeys []UnusedKey
+	for _, e := range r.UnusedKeys {
+		if keep(e.finding()) {
+			unusedKeys = append(unusedKeys, e)
+		}
+	}
+	r.UnusedKeys = unusedKeys
+
+	var unknown []UnknownTemplate
+	for _, e := range r.Unknown {
+		if keep(e.finding()) {
+			unknown = append(unknown, e)
+		}
+	}
+	r.Unknown = unknown
+
+	var unexported []UnexportedField
+	for _, e := range r.Unexported {
+		if keep(e.finding()) {
+			unexported = append(unexported, e)
+		}
+	}
+	r.Unexported = unexported
+
+	var funcArgs []FuncArgsError
+	for _, e := range r.FuncArgs {
+		if keep(e.finding()) {
+			funcArgs = append(funcArgs, e)
+		}
+	}
+	r.FuncArgs = funcArgs
+
+	var unverifiable []UnverifiableUsage
+	for _, e := range r.Unverifiable {
+		if keep(e.finding()) {
+			unverifiable = append(unverifiable, e)
+		}
+	}
+	r.Unverifiable = unverifiable
+
+	var unused []UnusedSuppression
+	for _, e := range r.Unused {
+		if keep(e.finding()) {
+			unused = append(unused, e)
+		}
+	}
+	r.Unused = unused
+}
+
+func (e MissingError) finding() Finding {
+	u := e.Usage
+	f := Finding{
+		Rule:     ruleByID(RuleMissingKey),
+		Severity: e.Severity,
+		Message:  e.message(),
+		Template: identPosition(e.TemplateIdent),
+		Go:       usagePosition(u),
+		Usage:    &u,
+		Value:    e,
+
+		Suggestions: e.Suggestions,
+	}
+	if len(e.TemplateIdents) > 1 {
+		for _, t := range e.TemplateIdents[1:] {
+			f.OtherTemplate = append(f.OtherTemplate, identPosition(t))
+		}
+	}
+	if len(e.Usages) > 1 {
+		f.OtherUsages = e.Usages[1:]
+	}
+	return f
+}
+
+func (e ParseError) finding() Finding {
+	return Finding{
+		Rule:     ruleByID(RuleParseError),
+		Severity: e.Severity,
+		Message:  e.Msg,
+		Template: Position{Path: e.Path, Line: e.Line, Col: e.Col},
+		Value:    e,
+	}
+}
+
+func (e UnverifiableUsage) finding() Finding {
+	u := e.Usage
+	return Finding{
+		Rule:     ruleByID(RuleUnverifiable),
+		Severity: e.Severity,
+		Message:  e.message(),
+		Go:       usagePosition(u),
+		Usage:    &u,
+		Value:    e,
+	}
+}
+
+func (e UnusedSuppression) finding() Finding {
+	f := Finding{
+		Rule:     ruleByID(RuleUnusedSuppression),
+		Severity: e.Severity,
+		Message:  e.message(),
+		Usage:    e.Usage,
+		Value:    e,
+	}
+	pos := Position{Path: e.Suppression.Path, Line: e.Suppression.Line, Col: e.Suppression.Col}
+	if e.Usage != nil {
+		f.Go = pos
+	} else {
+		f.Template = pos
+	}
+	return f
+}
+
+func (e UnusedKey) finding() Finding {
+	u := e.Usage
+	return Finding{
+		Rule:     ruleByID(RuleUnusedKey),
+		Severity: e.Severity,
+		Message:  e.message(),
+		Go:       usagePosition(u),
+		Usage:    &u,
+		Value:    e,
+	}
+}
+
+func (e UnknownTemplate) finding() Finding {
+	u := e.Usage
+	return Finding{
+		Rule:     ruleByID(RuleUnknownTemplate),
+		Severity: e.Severity,
+		Message:  e.message(),
+		Go:       usagePosition(u),
+		Usage:    &u,
+		Value:    e,
+
+		Suggestions: e.Suggestions,
+	}
+}
+
+func (e UnexportedField) finding() Finding {
+	u := e.Usage
+	return Finding{
+		Rule:     ruleByID(RuleUnexportedField),
+		Severity: e.Severity,
+		Message:  e.message(),
+		Template: identPosition(e.TemplateIdent),
+		Go:       usagePosition(u),
+		Usage:    &u,
+		Value:    e,
+	}
+}
+
+func (e FuncArgsError) finding() Finding {
+	return Finding{
+		Rule:     ruleByID(RuleBadFuncArgs),
+		Severity: e.Severity,
+		Message:  e.message(),
+		Template: identPosition(e.TemplateIdent),
+		Value:    e,
+	}
+}
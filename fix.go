@@ -0,0 +1,152 @@
+package tmplcheck
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Templates are fixed by replacing the bytes of identifiers at their
+// positions in the template source, rather than by printing parse trees,
+// which do not keep delimiters, trim markers and spacing, so that the
+// rest of the source is kept as it is.
+
+// Edit is the replacement of Old, from byte offset Pos to End of a
+// template file, with New.
+type Edit struct {
+	Path string // relative path of template file
+	Pos  int
+	End  int
+	Old  string
+	New  string
+}
+
+// confident returns the suggestions for the key that are almost
+// certainly meant: those that differ from it only in case, or by one
+// character or transposition for keys of four characters or more.
+func confident(key string, suggestions []string) []string {
+	var ret []string
+	for _, s := range suggestions {
+		if strings.EqualFold(key, s) || utf8.RuneCountInString(key) >= 4 && editDistance(key, s) == 1 {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// keyFix returns the edit that fixes the missing findings of the
+// identifier at index i of t, which are for each of the usages of the
+// template, or nil if there is none. There is one if no usage passes the
+// key, if the findings of all usages have the same single confident
+// suggestion, and if every usage passes it, as a key or as a field of
+// the value of the identifiers before it, so that the fix does not lead
+// to other findings.
+func keyFix(t TemplateIdent, i int, usages []Usage, missing []MissingError) *Edit {
+	if len(missing) == 0 || len(missing) != len(usages) {
+		return nil // passed by some usages, or suppressed
+	}
+	var fix string
+	idents := append([]string(nil), t.Idents...)
+	for _, e := range missing {
+		c := confident(e.MissingKey, e.Suggestions)
+		if len(c) != 1 || fix != "" && c[0] != fix {
+			return nil
+		}
+		fix = c[0]
+		idents[i] = fix
+		if !passes(e.Usage, idents, i) {
+			return nil
+		}
+	}
+	start, end, ok := identRange(t, i)
+	if !ok {
+		return nil
+	}
+	return &Edit{Path: t.Path, Pos: start, End: end, Old: t.Idents[i], New: fix}
+}
+
+// identRange returns the byte offsets of the start and end of the
+// identifier at index i of the field chain t, such as Name in
+// .User.Name. ok is false if the positions of t are not those of a
+// field chain.
+func identRange(t TemplateIdent, i int) (start, end int, ok bool) {
+	if int(t.End-t.Pos) != len("."+strings.Join(t.Idents, ".")) {
+		return 0, 0, false
+	}
+	start = int(t.Pos) + 1
+	for _, id := range t.Idents[:i] {
+		start += len(id) + 1
+	}
+	return start, start + len(t.Idents[i]), true
+}
+
+// Fixes returns the edits that fix findings of the result, by path of
+// template file, sorted and without duplicates. Findings are fixed if
+// they have a single suggestion that is almost certainly meant, such as
+// a key that differs only in case, for every Execute call of the
+// template. Fixes should be called before findings are grouped, as
+// grouped findings keep only the edit of their first position.
+func (r *Result) Fixes() map[string][]Edit {
+	ret := make(map[string][]Edit)
+	for i := range r.Templates {
+		t := &r.Templates[i]
+		for _, e := range t.Missing {
+			if e.Fix == nil {
+				continue
+			}
+			path := t.templatePath(e.Fix.Path)
+			if !containsEdit(ret[path], *e.Fix) {
+				ret[path] = append(ret[path], *e.Fix)
+			}
+		}
+	}
+	for _, edits := range ret {
+		sortEdits(edits)
+	}
+	return ret
+}
+
+func containsEdit(edits []Edit, e Edit) bool {
+	for _, v := range edits {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
+
+func sortEdits(edits []Edit) {
+	sort.Slice(edits, func(i, j int) bool {
+		if edits[i].Pos != edits[j].Pos {
+			return edits[i].Pos < edits[j].Pos
+		}
+		return edits[i].End < edits[j].End
+	})
+}
+
+// ApplyEdits returns src with the edits applied. It returns an error if
+// edits overlap or if the text they replace is not their Old text, as
+// when the file changed since it was checked.
+func ApplyEdits(src []byte, edits []Edit) ([]byte, error) {
+	edits = append([]Edit(nil), edits...)
+	sortEdits(edits)
+
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		if e.Pos < last || e.End < e.Pos || e.End > len(src) {
+			return nil, fmt.Errorf("%s: invalid or overlapping edit at offset %d", filepath.ToSlash(e.Path), e.Pos)
+		}
+		if string(src[e.Pos:e.End]) != e.Old {
+			return nil, fmt.Errorf("%s: expected %q at offset %d; the file may have changed", filepath.ToSlash(e.Path), e.Old, e.Pos)
+		}
+		buf.Write(src[last:e.Pos])
+		buf.WriteString(e.New)
+		last = e.End
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), nil
+}
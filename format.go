@@ -0,0 +1,145 @@
+package tmplcheck
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"text/template"
+)
+
+// FindingData is the data of a finding passed to the templates of
+// NewTemplateReporter, such as
+//
+//	{{.Template.File}}:{{.Template.Line}} {{.Key}}
+//
+// Fields are only added to FindingData and its field types, and not
+// renamed or removed, so that templates keep working. Paths use forward
+// slashes and are relative to the working directory if they are in it.
+type FindingData struct {
+	Rule        string   // rule ID, such as "TC001"
+	RuleName    string   // rule name, such as "missing-key"
+	Severity    string   // "error", "warning" or "info"
+	Message     string   // description, without positions
+	Key         string   // missing or unused key, or unexported field; empty for other rules
+	Fingerprint string   // identifier that is stable across runs; see Baseline
+	Suggestions []string // names that a missing key or unknown template may be a misspelling of
+
+	Template TemplateSide // the template side of the finding
+	Source   SourceSide   // the go source side of the finding
+
+	// OtherTemplate and OtherSource are the other uses in the template
+	// and the other Execute calls of a grouped finding, such as a key
+	// that is used several times and missing from several calls.
+	OtherTemplate []TemplateSide
+	OtherSource   []SourceSide
+}
+
+// TemplateSide is the template side of a finding in FindingData.
+type TemplateSide struct {
+	Name string // path relative to the templates directory; empty if the template is unknown
+	Root string // templates directory, when results for several directories are combined
+	File string // path of the template file; empty if the finding has no position in the template
+	Line int    // 0 if unknown
+	Col  int    // in characters; 0 if unknown
+
+	// EndLine and EndCol are the end of the key or field chain, with
+	// EndCol after its last character, or 0 if unknown.
+	EndLine int
+	EndCol  int
+}
+
+// SourceSide is the go source side of a finding in FindingData: the
+// Execute call, for findings that involve one.
+type SourceSide struct {
+	File string // path of the go source file; empty if the finding involves no call
+	Line int    // 0 if unknown
+	Col  int    // in characters; 0 if unknown
+	Call string // called method, such as "set.Execute"
+	Expr string // call expression, such as `set.Execute("page.html", w, data)`
+}
+
+// NewTemplateReporter returns a reporter that executes the text/template
+// text for each finding, with a FindingData, followed by a newline, in
+// the manner of go list -f.
+func NewTemplateReporter(text string) (Reporter, error) {
+	t, err := template.New("format").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return ReporterFunc(func(w io.Writer, res *Result) error {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		bw := bufio.NewWriter(w)
+		for i := range res.Templates {
+			r := &res.Templates[i]
+			for _, f := range r.Findings() {
+				if err := t.Execute(bw, r.findingData(wd, f)); err != nil {
+					return err
+				}
+				if err := bw.WriteByte('\n'); err != nil {
+					return err
+				}
+			}
+		}
+		return bw.Flush()
+	}), nil
+}
+
+// findingData returns the FindingData of the finding, with paths relative
+// to base.
+func (r *TemplateResult) findingData(base string, f Finding) FindingData {
+	tmpl, goFile := r.filePaths(base, f)
+	d := FindingData{
+		Rule:        f.Rule.ID,
+		RuleName:    f.Rule.Name,
+		Severity:    f.Severity.String(),
+		Message:     f.Message,
+		Fingerprint: f.Fingerprint(),
+		Suggestions: f.Suggestions,
+		Template: TemplateSide{
+			Name: r.Template,
+			Root: r.Root,
+			File: tmpl,
+			Line: f.Template.Line,
+			Col:  f.Template.Col,
+
+			EndLine: f.Template.EndLine,
+			EndCol:  f.Template.EndCol,
+		},
+	}
+	if goFile != "" {
+		d.Source = SourceSide{
+			File: goFile,
+			Line: f.Go.Line,
+			Col:  f.Go.Col,
+			Call: f.Usage.Obj + "." + f.Usage.Call,
+			Expr: f.Usage.Expr,
+		}
+	}
+	for _, pos := range f.OtherTemplate {
+		t := d.Template
+		t.File = outputPath(base, r.templatePath(pos.Path))
+		t.Line, t.Col, t.EndLine, t.EndCol = pos.Line, pos.Col, pos.EndLine, pos.EndCol
+		d.OtherTemplate = append(d.OtherTemplate, t)
+	}
+	for _, u := range f.OtherUsages {
+		d.OtherSource = append(d.OtherSource, SourceSide{
+			File: outputPath(base, u.Filename),
+			Line: u.Line,
+			Col:  u.Col,
+			Call: u.Obj + "." + u.Call,
+			Expr: u.Expr,
+		})
+	}
+	switch e := f.Value.(type) {
+	case MissingError:
+		d.Key = e.MissingKey
+	case UnusedKey:
+		d.Key = e.Key
+	case UnexportedField:
+		d.Key = e.Field
+	}
+	return d
+}
@@ -0,0 +1,60 @@
+package tmplcheck
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// matchGlob reports whether the slash-separated relative path matches
+// the pattern. Patterns use the syntax of path.Match, where "*" does
+// not match "/", and a "**" element matches any number of directories.
+// A pattern without "/" matches the base name of the path, so that
+// "*.txt" matches "a/b.txt".
+func matchGlob(pattern, relpath string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(relpath))
+		return ok
+	}
+	return matchElems(strings.Split(pattern, "/"), strings.Split(relpath, "/"))
+}
+
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// includes reports whether the template at relpath, relative to
+// c.Templates, is checked according to c.Include and c.Ignore.
+func (c *Config) includes(relpath string) bool {
+	p := filepath.ToSlash(relpath)
+	if len(c.Include) != 0 && !matchAny(c.Include, p) {
+		return false
+	}
+	return !matchAny(c.Ignore, p)
+}
+
+func matchAny(patterns []string, relpath string) bool {
+	for _, pat := range patterns {
+		if matchGlob(pat, relpath) {
+			return true
+		}
+	}
+	return false
+}
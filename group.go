@@ -0,0 +1,209 @@
+package tmplcheck
+
+import (
+	"sort"
+	"strings"
+)
+
+// Results are sorted so that output does not change between runs with
+// the same findings. Check reports a missing-key finding for each use
+// of a key in a template and each Execute call that does not pass it;
+// Group merges them into a finding for each key and chain of fields.
+
+// Sort sorts the templates of the result by Root and Template, and the
+// findings of each template by position. Check returns sorted results;
+// Sort is for results that are combined or modified.
+func (r *Result) Sort() {
+	sort.SliceStable(r.Templates, func(i, j int) bool {
+		a, b := &r.Templates[i], &r.Templates[j]
+		if a.Root != b.Root {
+			return a.Root < b.Root
+		}
+		return a.Template < b.Template
+	})
+	for i := range r.Templates {
+		r.Templates[i].Sort()
+	}
+}
+
+// Sort sorts the findings of each rule by their position in the
+// template, then in go source, then by message.
+func (r *TemplateResult) Sort() {
+	sort.SliceStable(r.Missing, func(i, j int) bool {
+		return findingLess(r.Missing[i].finding(), r.Missing[j].finding())
+	})
+	sort.SliceStable(r.UnusedKeys, func(i, j int) bool {
+		return findingLess(r.UnusedKeys[i].finding(), r.UnusedKeys[j].finding())
+	})
+	sort.SliceStable(r.Unknown, func(i, j int) bool {
+		return findingLess(r.Unknown[i].finding(), r.Unknown[j].finding())
+	})
+	sort.SliceStable(r.Unexported, func(i, j int) bool {
+		return findingLess(r.Unexported[i].finding(), r.Unexported[j].finding())
+	})
+	sort.SliceStable(r.FuncArgs, func(i, j int) bool {
+		return findingLess(r.FuncArgs[i].finding(), r.FuncArgs[j].finding())
+	})
+	sort.SliceStable(r.Unverifiable, func(i, j int) bool {
+		return findingLess(r.Unverifiable[i].finding(), r.Unverifiable[j].finding())
+	})
+	sort.SliceStable(r.Unused, func(i, j int) bool {
+		return findingLess(r.Unused[i].finding(), r.Unused[j].finding())
+	})
+}
+
+func findingLess(a, b Finding) bool {
+	if c := comparePositions(a.Template, b.Template); c != 0 {
+		return c < 0
+	}
+	if c := comparePositions(a.Go, b.Go); c != 0 {
+		return c < 0
+	}
+	return a.Message < b.Message
+}
+
+// comparePositions returns -1, 0 or 1 as a is before, at or after b.
+// Invalid positions are first.
+func comparePositions(a, b Position) int {
+	switch {
+	case a.Path != b.Path:
+		return compareStrings(a.Path, b.Path)
+	case a.Line != b.Line:
+		return compareInts(a.Line, b.Line)
+	case a.Col != b.Col:
+		return compareInts(a.Col, b.Col)
+	}
+	return 0
+}
+
+func compareStrings(a, b string) int {
+	if a < b {
+		return -1
+	}
+	return 1
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	}
+	return 1
+}
+
+// Group groups the findings of each template of the result; see
+// TemplateResult.Group.
+func (r *Result) Group() {
+	for i := range r.Templates {
+		r.Templates[i].Group()
+	}
+}
+
+// Group merges the missing-key findings of the template that are about
+// the same key, reached by the same chain of fields, into one, which
+// lists every use of the chain in the template in TemplateIdents and
+// every Execute call that does not pass the key in Usages, with the
+// suggestions of all. Its TemplateIdent and Usage are the first of them,
+// and its fingerprint is that of the first finding. The merged findings
+// are kept, so that they can be reported one by one. Other findings are
+// not changed. Findings are grouped in the order of Missing, which
+// should be sorted.
+func (r *TemplateResult) Group() {
+	var ret []MissingError
+	index := make(map[string]int) // chain -> index in ret
+	for _, e := range r.Missing {
+		i, ok := index[e.chain()]
+		if !ok {
+			index[e.chain()] = len(ret)
+			e.merged = append([]MissingError(nil), e.flat()...)
+			e.TemplateIdents = e.idents()
+			e.Usages = e.usages()
+			ret = append(ret, e)
+			continue
+		}
+		g := &ret[i]
+		for _, t := range e.idents() {
+			if !containsIdentAt(g.TemplateIdents, t) {
+				g.TemplateIdents = append(g.TemplateIdents, t)
+			}
+		}
+		for _, u := range e.usages() {
+			if !containsUsage(g.Usages, u) {
+				g.Usages = append(g.Usages, u)
+			}
+		}
+		g.merged = append(g.merged, e.flat()...)
+		g.Suggestions = mergeSuggestions(g.Suggestions, e.Suggestions)
+	}
+	r.Missing = ret
+}
+
+// chain returns the chain of fields of the template identifier up to the
+// missing key, such as User.Name for Name in .User.Name.Email, or the key
+// if it is not known.
+func (e MissingError) chain() string {
+	idents := e.TemplateIdent.Idents
+	if e.index < len(idents) && idents[e.index] == e.MissingKey {
+		return strings.Join(idents[:e.index+1], ".")
+	}
+	return e.MissingKey
+}
+
+// flat returns the findings merged into the finding by Group, or the
+// finding itself if it is not grouped.
+func (e MissingError) flat() []MissingError {
+	if e.merged != nil {
+		return e.merged
+	}
+	e.TemplateIdents, e.Usages = nil, nil
+	return []MissingError{e}
+}
+
+// idents returns the uses of the key of the finding in the template.
+func (e MissingError) idents() []TemplateIdent {
+	if len(e.TemplateIdents) != 0 {
+		return e.TemplateIdents
+	}
+	return []TemplateIdent{e.TemplateIdent}
+}
+
+// usages returns the Execute calls of the finding.
+func (e MissingError) usages() []Usage {
+	if len(e.Usages) != 0 {
+		return e.Usages
+	}
+	return []Usage{e.Usage}
+}
+
+func containsIdentAt(idents []TemplateIdent, t TemplateIdent) bool {
+	for _, v := range idents {
+		if v.Path == t.Path && v.Pos == t.Pos {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUsage(usages []Usage, u Usage) bool {
+	for _, v := range usages {
+		if v.Filename == u.Filename && v.Line == u.Line && v.Col == u.Col {
+			return true
+		}
+	}
+	return false
+}
+
+// flatFindings returns the findings of the result with the missing-key
+// findings merged by Group split again into those that were merged, as
+// Check reports them, for output formats that have a single position for
+// each finding. The result is not changed.
+func (r *TemplateResult) flatFindings() []Finding {
+	flat := *r
+	flat.Missing = nil
+	for _, e := range r.Missing {
+		flat.Missing = append(flat.Missing, e.flat()...)
+	}
+	sort.SliceStable(flat.Missing, func(i, j int) bool {
+		return findingLess(flat.Missing[i].finding(), flat.Missing[j].finding())
+	})
+	return flat.Findings()
+}
@@ -0,0 +1,371 @@
+package tmplcheck
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"sort"
+	"unicode/utf8"
+)
+
+// The html output format is a single HTML page, that needs no other
+// files or network access, for reviewing the findings: counts by rule
+// and by directory, and the source of each template with the positions
+// of findings highlighted. Hovering a highlight shows the findings, with
+// links to the lines of the Execute calls, which are shown under the
+// template.
+
+type htmlReport struct {
+	Total      int
+	Severities []htmlCount
+	Rules      []htmlCount
+	Dirs       []htmlCount
+	Templates  []*htmlTemplate
+}
+
+type htmlCount struct {
+	Name  string
+	Title string
+	Count int
+}
+
+type htmlTemplate struct {
+	ID       string // anchor
+	Name     string
+	Findings int
+	Lines    []htmlLine
+	Other    []htmlFinding // findings without a position in the template source
+	Calls    []*htmlCall
+}
+
+type htmlLine struct {
+	N     int
+	Parts []htmlPart
+}
+
+// htmlPart is text of a template line, highlighted if it has findings.
+type htmlPart struct {
+	Text     string
+	Severity string // of the most severe finding
+	Findings []htmlFinding
+}
+
+type htmlFinding struct {
+	Rule     *Rule
+	Severity string
+	Message  string
+	Position string // in the template or in go source
+	Calls    []*htmlCall
+}
+
+// htmlCall is the source around an Execute call.
+type htmlCall struct {
+	ID    string // anchor
+	File  string
+	Line  int
+	Expr  string
+	Lines []htmlCallLine
+}
+
+type htmlCallLine struct {
+	N    int
+	Text string
+	Call bool // the line of the call
+}
+
+// htmlCallContext is the number of lines shown before and after calls.
+const htmlCallContext = 2
+
+// htmlMark is a finding at a byte range of a template line.
+type htmlMark struct {
+	start, end int
+	finding    htmlFinding
+	severity   Severity
+}
+
+func writeHTML(w io.Writer, res *Result) error {
+	base, err := outputBase(res)
+	if err != nil {
+		return err
+	}
+
+	report := &htmlReport{}
+	severities := make(map[Severity]int)
+	rules := make(map[*Rule]int)
+	dirs := make(map[string]int)
+	files := make(fileCache)
+
+	results := make([]*TemplateResult, len(res.Templates))
+	for i := range res.Templates {
+		results[i] = &res.Templates[i]
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].displayName() < results[j].displayName()
+	})
+
+	for i, r := range results {
+		name := r.displayName()
+		t := &htmlTemplate{ID: fmt.Sprintf("t%d", i), Name: name}
+		calls := make(map[string]*htmlCall)
+		marks := make(map[int][]htmlMark) // by line
+
+		var lines []string
+		if r.Template != "" {
+			lines = files.lines(r.templatePath(r.Template))
+		}
+
+		for _, f := range r.Findings() {
+			severities[f.Severity]++
+			rules[f.Rule]++
+			dirs[path.Dir(name)]++
+			t.Findings++
+
+			tmpl, goFile := r.filePaths(base, f)
+			hf := htmlFinding{
+				Rule:     f.Rule,
+				Severity: f.Severity.String(),
+				Message:  f.Message,
+			}
+			callOf := func(u *Usage) *htmlCall {
+				file := outputPath(base, u.Filename)
+				key := fmt.Sprintf("%s:%d", file, u.Line)
+				c, ok := calls[key]
+				if !ok {
+					c = &htmlCall{
+						ID:    fmt.Sprintf("%s-c%d", t.ID, len(t.Calls)),
+						File:  file,
+						Line:  u.Line,
+						Expr:  u.Expr,
+						Lines: callLines(files.lines(u.Filename), u.Line),
+					}
+					calls[key] = c
+					t.Calls = append(t.Calls, c)
+				}
+				return c
+			}
+			if goFile != "" {
+				hf.Calls = append(hf.Calls, callOf(f.Usage))
+			}
+			for i := range f.OtherUsages {
+				hf.Calls = append(hf.Calls, callOf(&f.OtherUsages[i]))
+			}
+
+			// Grouped findings are marked at each of their positions.
+			marked := false
+			for _, pos := range append([]Position{f.Template}, f.OtherTemplate...) {
+				if tmpl != "" && pos.Line > 0 && pos.Line <= len(lines) && pos.Path == r.Template {
+					m := htmlMark{finding: hf, severity: f.Severity}
+					m.finding.Position = positionString(outputPath(base, r.templatePath(pos.Path)), pos)
+					m.start, m.end = markRange(lines[pos.Line-1], pos)
+					marks[pos.Line] = append(marks[pos.Line], m)
+					marked = true
+				}
+			}
+			if marked {
+				continue
+			}
+			pos := f.Template
+			switch {
+			case tmpl != "":
+				hf.Position = positionString(tmpl, pos)
+			case goFile != "":
+				hf.Position = positionString(goFile, f.Go)
+			}
+			t.Other = append(t.Other, hf)
+		}
+
+		for n, text := range lines {
+			t.Lines = append(t.Lines, htmlLine{N: n + 1, Parts: lineParts(text, marks[n+1])})
+		}
+		report.Templates = append(report.Templates, t)
+		report.Total += t.Findings
+	}
+
+	for sev := SeverityError; sev > SeverityOff; sev-- {
+		report.Severities = append(report.Severities, htmlCount{Name: sev.String(), Count: severities[sev]})
+	}
+	for _, r := range Rules {
+		if n := rules[r]; n != 0 {
+			report.Rules = append(report.Rules, htmlCount{Name: r.ID + " " + r.Name, Title: r.Summary, Count: n})
+		}
+	}
+	for dir, n := range dirs {
+		report.Dirs = append(report.Dirs, htmlCount{Name: dir, Count: n})
+	}
+	sort.Slice(report.Dirs, func(i, j int) bool {
+		return report.Dirs[i].Name < report.Dirs[j].Name
+	})
+
+	return htmlTmpl.Execute(w, report)
+}
+
+// markRange returns the byte range of the line to highlight for the
+// position: up to its end if the end is on the line, or else the token at
+// the position.
+func markRange(line string, pos Position) (start, end int) {
+	start = byteOffset(line, pos.Col)
+	if pos.EndLine == pos.Line && pos.EndCol > pos.Col {
+		return start, byteOffset(line, pos.EndCol)
+	}
+	return tokenRange(line, start)
+}
+
+// tokenRange returns the byte range of the identifier, field chain or
+// variable at the byte offset in the line, or of the character at the
+// offset if there is none.
+func tokenRange(line string, start int) (int, int) {
+	if start >= len(line) {
+		return len(line), len(line)
+	}
+	end := start
+	for end < len(line) && isTokenByte(line[end]) {
+		end++
+	}
+	if end == start {
+		_, size := utf8.DecodeRuneInString(line[start:])
+		end += size
+	}
+	return start, end
+}
+
+func isTokenByte(c byte) bool {
+	return c == '.' || c == '$' || c == '_' || '0' <= c && c <= '9' ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+// lineParts splits the line into parts with the marks highlighted.
+// Overlapping marks are merged.
+func lineParts(line string, marks []htmlMark) []htmlPart {
+	sort.SliceStable(marks, func(i, j int) bool {
+		return marks[i].start < marks[j].start
+	})
+
+	var parts []htmlPart
+	cur := -1 // index of the last highlighted part
+	var curSev Severity
+	pos := 0 // end of the last part
+	for _, m := range marks {
+		if cur >= 0 && m.start < pos {
+			p := &parts[cur]
+			p.Findings = append(p.Findings, m.finding)
+			if m.end > pos {
+				p.Text += line[pos:m.end]
+				pos = m.end
+			}
+			if m.severity > curSev {
+				curSev = m.severity
+				p.Severity = m.severity.String()
+			}
+			continue
+		}
+		if m.start > pos {
+			parts = append(parts, htmlPart{Text: line[pos:m.start]})
+		}
+		parts = append(parts, htmlPart{
+			Text:     line[m.start:m.end],
+			Severity: m.severity.String(),
+			Findings: []htmlFinding{m.finding},
+		})
+		cur, curSev = len(parts)-1, m.severity
+		pos = m.end
+	}
+	if pos < len(line) || len(parts) == 0 {
+		parts = append(parts, htmlPart{Text: line[pos:]})
+	}
+	return parts
+}
+
+// callLines returns the lines around the line of a call.
+func callLines(lines []string, line int) []htmlCallLine {
+	var ret []htmlCallLine
+	for n := line - htmlCallContext; n <= line+htmlCallContext; n++ {
+		if n >= 1 && n <= len(lines) {
+			ret = append(ret, htmlCallLine{N: n, Text: lines[n-1], Call: n == line})
+		}
+	}
+	return ret
+}
+
+var htmlTmpl = template.Must(template.New("html").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>tmplcheck report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 0.5em 0 1.5em; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; }
+td.n { text-align: right; }
+pre, code { font-family: monospace; font-size: 13px; }
+.src { border: 1px solid #ddd; background: #fafafa; padding: 0.5em 0; overflow: visible; }
+.ln { display: inline-block; width: 4em; padding-right: 1em; text-align: right; color: #999; user-select: none; }
+.call { background: #fff3c4; }
+.mark { position: relative; border-bottom: 2px solid; cursor: help; }
+.mark.error { background: #fde2e1; border-color: #d73a49; }
+.mark.warning { background: #fff5d1; border-color: #dbab09; }
+.mark.info { background: #e1effe; border-color: #0366d6; }
+.tip { display: none; position: absolute; left: 0; top: 1.4em; z-index: 1; min-width: 30em; padding: 0.5em; background: #fff; border: 1px solid #999; box-shadow: 0 2px 6px rgba(0,0,0,0.2); white-space: normal; font-family: sans-serif; }
+.tipline { display: block; }
+.mark:hover .tip, .mark:focus .tip, .mark:focus-within .tip { display: block; }
+.sev { font-weight: bold; }
+.sev.error { color: #d73a49; }
+.sev.warning { color: #b08800; }
+.sev.info { color: #0366d6; }
+.rule { color: #666; }
+h2 { margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1>tmplcheck report</h1>
+<p>{{.Total}} findings in {{len .Templates}} templates:
+{{- range $i, $s := .Severities}}{{if $i}},{{end}} {{$s.Count}} {{$s.Name}}{{end}}.</p>
+
+<h2>By rule</h2>
+<table>
+<tr><th>Rule</th><th>Findings</th></tr>
+{{range .Rules}}<tr><td title="{{.Title}}">{{.Name}}</td><td class="n">{{.Count}}</td></tr>
+{{end}}</table>
+
+<h2>By directory</h2>
+<table>
+<tr><th>Directory</th><th>Findings</th></tr>
+{{range .Dirs}}<tr><td>{{.Name}}</td><td class="n">{{.Count}}</td></tr>
+{{end}}</table>
+
+<h2>Templates</h2>
+<ul>
+{{range .Templates}}<li><a href="#{{.ID}}">{{.Name}}</a> ({{.Findings}})</li>
+{{end}}</ul>
+{{range .Templates}}
+<h2 id="{{.ID}}">{{.Name}}</h2>
+{{- if .Other}}
+<ul>
+{{range .Other}}<li>{{template "finding" .}}</li>
+{{end}}</ul>
+{{- end}}
+{{- if .Lines}}
+<pre class="src">
+{{- range .Lines}}
+<span class="ln">{{.N}}</span>{{range .Parts}}{{if .Findings}}<span class="mark {{.Severity}}" tabindex="0">{{.Text}}<span class="tip">{{range .Findings}}<span class="tipline">{{template "finding" .}}</span>{{end}}</span></span>{{else}}{{.Text}}{{end}}{{end}}
+{{- end}}
+</pre>
+{{- end}}
+{{- range .Calls}}
+<h3 id="{{.ID}}"><code>{{.File}}:{{.Line}}</code></h3>
+<pre class="src">
+{{- range .Lines}}
+<span{{if .Call}} class="call"{{end}}><span class="ln">{{.N}}</span>{{.Text}}</span>
+{{- end}}
+</pre>
+{{- end}}
+{{end}}
+</body>
+</html>
+{{define "finding" -}}
+<span class="sev {{.Severity}}">{{.Severity}}</span>
+{{.Message}} <span class="rule" title="{{.Rule.Summary}}">[{{.Rule.ID}} {{.Rule.Name}}]</span>
+{{- if .Position}} <code>{{.Position}}</code>{{end}}
+{{- range .Calls}} <a href="#{{.ID}}">{{.Expr}}</a>{{end}}
+{{- end}}
+`))
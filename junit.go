@@ -0,0 +1,120 @@
+package tmplcheck
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The junit output format is JUnit XML, for CI services that show test
+// results. Each template is a test suite, and each finding a failing
+// test case. Templates without findings have a single passing test case.
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitPassed is the name of the test case of templates without
+// findings.
+const junitPassed = "tmplcheck"
+
+func writeJUnit(w io.Writer, res *Result) error {
+	base, err := outputBase(res)
+	if err != nil {
+		return err
+	}
+
+	suites := junitTestSuites{Name: "tmplcheck"}
+	for i := range res.Templates {
+		r := &res.Templates[i]
+		name := r.displayName()
+		suite := junitTestSuite{Name: name}
+		for _, f := range r.Findings() {
+			tmpl, goFile := r.filePaths(base, f)
+			var text strings.Builder
+			fmt.Fprintf(&text, "%s: %s\n", f.Severity, f.Message)
+			if tmpl != "" {
+				fmt.Fprintf(&text, "%s\n", positionString(tmpl, f.Template))
+			}
+			for _, pos := range f.OtherTemplate {
+				fmt.Fprintf(&text, "%s\n", positionString(outputPath(base, r.templatePath(pos.Path)), pos))
+			}
+			if goFile != "" {
+				fmt.Fprintf(&text, "%s: %s\n", positionString(goFile, f.Go), f.Usage.Expr)
+			}
+			for _, u := range f.OtherUsages {
+				fmt.Fprintf(&text, "%s: %s\n", positionString(outputPath(base, u.Filename), usagePosition(u)), u.Expr)
+			}
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      fmt.Sprintf("%s %s: %s", f.Rule.ID, f.Rule.Name, f.Message),
+				ClassName: name,
+				Failure: &junitFailure{
+					Message: f.Message,
+					Type:    f.Rule.ID,
+					Text:    text.String(),
+				},
+			})
+			suite.Failures++
+		}
+		if len(suite.Cases) == 0 {
+			suite.Cases = []junitTestCase{{Name: junitPassed, ClassName: name}}
+		}
+		suite.Tests = len(suite.Cases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	return writeXML(w, suites)
+}
+
+// writeXML writes the XML declaration and v, indented.
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// positionString returns the position in the file at path, in the form
+// path:line:col, omitting unknown parts.
+func positionString(path string, pos Position) string {
+	switch {
+	case pos.Line == 0:
+		return path
+	case pos.Col == 0:
+		return fmt.Sprintf("%s:%d", path, pos.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", path, pos.Line, pos.Col)
+}
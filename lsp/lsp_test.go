@@ -0,0 +1,223 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-web-framework/tmplcheck"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// client is a language client talking to a server started by Serve.
+type client struct {
+	conn  *conn
+	msgs  chan *message // messages read from the server
+	id    int
+	diags map[string][]diagnostic // latest published diagnostics by URI
+
+	registrations []registration // registered with client/registerCapability
+}
+
+// request sends the request and returns the response, handling the
+// notifications received in the meantime.
+func (c *client) request(method string, params interface{}) *message {
+	c.id++
+	id := json.RawMessage(strconv.Itoa(c.id))
+	b, err := json.Marshal(params)
+	So(err, ShouldBeNil)
+	So(c.conn.write(&message{ID: &id, Method: method, Params: b}), ShouldBeNil)
+
+	for {
+		m, ok := <-c.msgs
+		So(ok, ShouldBeTrue)
+		if m.ID == nil || m.Method != "" {
+			c.handle(m)
+			continue
+		}
+		So(string(*m.ID), ShouldEqual, string(id))
+		return m
+	}
+}
+
+// call sends the request and decodes the result into result.
+func (c *client) call(method string, params, result interface{}) {
+	m := c.request(method, params)
+	So(m.Error, ShouldBeNil)
+	if result != nil {
+		So(json.Unmarshal(m.Result, result), ShouldBeNil)
+	}
+}
+
+// notify sends the notification. A request is sent afterwards to
+// receive the notifications sent by the server in response, since
+// requests are handled in order.
+func (c *client) notify(method string, params interface{}) {
+	So(c.conn.notify(method, params), ShouldBeNil)
+	m := c.request("tmplcheck/unknown", nil)
+	So(m.Error.Code, ShouldEqual, codeMethodNotFound)
+}
+
+// handle handles a notification or request of the server.
+func (c *client) handle(m *message) {
+	switch m.Method {
+	case "textDocument/publishDiagnostics":
+		var p publishDiagnosticsParams
+		So(json.Unmarshal(m.Params, &p), ShouldBeNil)
+		c.diags[p.URI] = p.Diagnostics
+	case "client/registerCapability":
+		var p struct {
+			Registrations []registration `json:"registrations"`
+		}
+		So(json.Unmarshal(m.Params, &p), ShouldBeNil)
+		c.registrations = append(c.registrations, p.Registrations...)
+		So(c.conn.reply(m.ID, nil, nil), ShouldBeNil)
+	}
+}
+
+func startServer(t *testing.T) (*client, func()) {
+	templates, err := filepath.Abs(filepath.Join("testdata", "app", "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(context.Background(), sr, sw, &tmplcheck.Config{
+			Package:   "github.com/go-web-framework/tmplcheck/lsp/testdata/app",
+			Templates: templates,
+		})
+		sw.Close()
+	}()
+
+	// Messages are read concurrently, since the server may be blocked
+	// writing notifications while the client writes a request.
+	c := &client{conn: newConn(cr, cw), msgs: make(chan *message, 100), diags: make(map[string][]diagnostic)}
+	go func() {
+		defer close(c.msgs)
+		for {
+			m, err := c.conn.read()
+			if err != nil {
+				return
+			}
+			c.msgs <- m
+		}
+	}()
+	return c, func() {
+		So(c.conn.notify("exit", nil), ShouldBeNil)
+		So(<-done, ShouldBeNil)
+	}
+}
+
+func TestServer(t *testing.T) {
+	index, err := filepath.Abs(filepath.Join("testdata", "app", "templates", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexURI := pathToURI(index)
+	text := "<h1>{{.Title}}</h1>\n<p>{{.User.Email}}</p>\n<p>{{.Subtitle}}</p>\n"
+
+	Convey("server", t, func() {
+		c, stop := startServer(t)
+		defer stop()
+
+		c.call("initialize", map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"workspace": map[string]interface{}{
+					"didChangeWatchedFiles": map[string]interface{}{"dynamicRegistration": true},
+				},
+			},
+		}, nil)
+		c.notify("initialized", map[string]interface{}{})
+		c.notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": indexURI, "text": text},
+		})
+
+		at := func(line, char int) textDocumentPositionParams {
+			return textDocumentPositionParams{
+				TextDocument: textDocumentIdentifier{URI: indexURI},
+				Position:     position{line, char},
+			}
+		}
+
+		Convey("publishes diagnostics", func() {
+			var found bool
+			for _, d := range c.diags[indexURI] {
+				if strings.Contains(d.Message, `"Subtitle"`) {
+					found = true
+					So(d.Range, ShouldResemble, span{position{2, 5}, position{2, 14}})
+					So(d.RelatedInformation, ShouldHaveLength, 1)
+					So(d.RelatedInformation[0].Location.URI, ShouldEndWith, "/app.go")
+				}
+			}
+			So(found, ShouldBeTrue)
+
+			Convey("and updates them as the template changes", func() {
+				c.notify("textDocument/didChange", map[string]interface{}{
+					"textDocument":   map[string]interface{}{"uri": indexURI},
+					"contentChanges": []map[string]interface{}{{"text": "<h1>{{.Title}}</h1>\n"}},
+				})
+				So(c.diags[indexURI], ShouldBeEmpty)
+			})
+		})
+
+		Convey("registers watchers for go files and templates", func() {
+			So(c.registrations, ShouldHaveLength, 1)
+			So(c.registrations[0].Method, ShouldEqual, "workspace/didChangeWatchedFiles")
+			b, err := json.Marshal(c.registrations[0].RegisterOptions)
+			So(err, ShouldBeNil)
+			var opts didChangeWatchedFilesRegistrationOptions
+			So(json.Unmarshal(b, &opts), ShouldBeNil)
+			So(opts.Watchers, ShouldResemble, []fileSystemWatcher{
+				{GlobPattern: "**/*.go"},
+				{GlobPattern: filepath.ToSlash(filepath.Dir(index)) + "/**/*"},
+			})
+		})
+
+		Convey("hover shows the type and doc comment", func() {
+			var h hover
+			c.call("textDocument/hover", at(1, 15), &h)
+			So(h.Contents.Value, ShouldContainSubstring, "field Email string")
+			So(h.Contents.Value, ShouldContainSubstring, "Email is the primary email address of the user.")
+			So(*h.Range, ShouldResemble, span{position{1, 11}, position{1, 16}})
+		})
+
+		Convey("completion lists fields and methods", func() {
+			labels := func(line, char int) []string {
+				var l completionList
+				c.call("textDocument/completion", at(line, char), &l)
+				var ret []string
+				for _, item := range l.Items {
+					ret = append(ret, item.Label)
+				}
+				return ret
+			}
+			So(labels(1, 11), ShouldResemble, []string{"Email", "Initials", "Name"})
+			So(labels(0, 7), ShouldResemble, []string{"Title", "User"})
+		})
+
+		Convey("definition jumps to the struct field", func() {
+			var locs []location
+			c.call("textDocument/definition", at(1, 15), &locs)
+			So(locs, ShouldHaveLength, 1)
+			So(locs[0].URI, ShouldEndWith, "/testdata/app/app.go")
+			So(locs[0].Range.Start, ShouldResemble, position{10, 1})
+		})
+	})
+}
+
+func TestPosition(t *testing.T) {
+	Convey("positions are in UTF-16 code units", t, func() {
+		text := "a\nü😀x\n"
+		So(toPosition(text, 2), ShouldResemble, position{1, 0})
+		So(toPosition(text, 8), ShouldResemble, position{1, 3})
+		So(toOffset(text, position{1, 3}), ShouldEqual, 8)
+		So(toOffset(text, position{5, 0}), ShouldEqual, len(text))
+	})
+}
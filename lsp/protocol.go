@@ -0,0 +1,324 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// This file contains the subset of the Language Server Protocol and
+// JSON-RPC 2.0 used by the server. See
+// https://microsoft.github.io/language-server-protocol/specification.
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"` // nil for notifications
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string { return e.Message }
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidParams  = -32602
+	codeMethodNotFound = -32601
+	codeInternalError  = -32603
+)
+
+// conn reads and writes messages with the base protocol framing: a
+// Content-Length header followed by the JSON content.
+type conn struct {
+	r *bufio.Reader
+
+	mu sync.Mutex // guards w
+	w  io.Writer
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+func (c *conn) read() (*message, error) {
+	h, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("lsp: bad Content-Length: %v", err)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return nil, err
+	}
+	var m message
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, &responseError{codeParseError, err.Error()}
+	}
+	return &m, nil
+}
+
+func (c *conn) write(m *message) error {
+	m.JSONRPC = "2.0"
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(b)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(b)
+	return err
+}
+
+func (c *conn) notify(method string, params interface{}) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: b})
+}
+
+// request sends a request to the client. Responses are read by the
+// server like other messages and ignored.
+func (c *conn) request(id int, method string, params interface{}) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	raw := json.RawMessage(strconv.Itoa(id))
+	return c.write(&message{ID: &raw, Method: method, Params: b})
+}
+
+func (c *conn) reply(id *json.RawMessage, result interface{}, err error) error {
+	if err != nil {
+		var re *responseError
+		if !errors.As(err, &re) {
+			re = &responseError{codeInternalError, err.Error()}
+		}
+		return c.write(&message{ID: id, Error: re})
+	}
+	b, err := json.Marshal(result) // null for a nil result
+	if err != nil {
+		return err
+	}
+	return c.write(&message{ID: id, Result: b})
+}
+
+type position struct {
+	Line      int `json:"line"`      // zero-based
+	Character int `json:"character"` // zero-based, in UTF-16 code units
+}
+
+type span struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string `json:"uri"`
+	Range span   `json:"range"`
+}
+
+type diagnostic struct {
+	Range              span                 `json:"range"`
+	Severity           int                  `json:"severity,omitempty"`
+	Code               string               `json:"code,omitempty"`
+	Source             string               `json:"source,omitempty"`
+	Message            string               `json:"message"`
+	RelatedInformation []relatedInformation `json:"relatedInformation,omitempty"`
+}
+
+// Diagnostic severities.
+const (
+	severityError       = 1
+	severityWarning     = 2
+	severityInformation = 3
+)
+
+type relatedInformation struct {
+	Location location `json:"location"`
+	Message  string   `json:"message"`
+}
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type didOpenParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Range *span  `json:"range,omitempty"`
+		Text  string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didSaveParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type initializeParams struct {
+	Capabilities struct {
+		Workspace struct {
+			DidChangeWatchedFiles struct {
+				DynamicRegistration bool `json:"dynamicRegistration"`
+			} `json:"didChangeWatchedFiles"`
+		} `json:"workspace"`
+	} `json:"capabilities"`
+}
+
+type registrationParams struct {
+	Registrations []registration `json:"registrations"`
+}
+
+type registration struct {
+	ID              string      `json:"id"`
+	Method          string      `json:"method"`
+	RegisterOptions interface{} `json:"registerOptions,omitempty"`
+}
+
+type didChangeWatchedFilesRegistrationOptions struct {
+	Watchers []fileSystemWatcher `json:"watchers"`
+}
+
+type fileSystemWatcher struct {
+	GlobPattern string `json:"globPattern"`
+}
+
+type didChangeWatchedFilesParams struct {
+	Changes []struct {
+		URI string `json:"uri"`
+	} `json:"changes"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    *span         `json:"range,omitempty"`
+}
+
+type completionItem struct {
+	Label         string `json:"label"`
+	Kind          int    `json:"kind,omitempty"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// Completion item kinds.
+const (
+	completionMethod   = 2
+	completionField    = 5
+	completionProperty = 10
+)
+
+type completionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []completionItem `json:"items"`
+}
+
+// pathToURI returns the file URI for the absolute path.
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// uriToPath returns the path for the file URI.
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("lsp: unsupported URI scheme: %q", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// toPosition returns the LSP position of the byte offset in text.
+func toPosition(text string, offset int) position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	var p position
+	lineStart := 0
+	for i := 0; i < offset; i++ {
+		if text[i] == '\n' {
+			p.Line++
+			lineStart = i + 1
+		}
+	}
+	for _, r := range text[lineStart:offset] {
+		p.Character += len(utf16.Encode([]rune{r}))
+	}
+	return p
+}
+
+// toOffset returns the byte offset in text of the LSP position.
+func toOffset(text string, p position) int {
+	i := 0
+	for line := 0; line < p.Line; line++ {
+		j := indexByteFrom(text, '\n', i)
+		if j < 0 {
+			return len(text)
+		}
+		i = j + 1
+	}
+	for col := 0; col < p.Character && i < len(text) && text[i] != '\n'; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		col += len(utf16.Encode([]rune{r}))
+		i += size
+	}
+	return i
+}
+
+func indexByteFrom(s string, c byte, from int) int {
+	if i := strings.IndexByte(s[from:], c); i >= 0 {
+		return from + i
+	}
+	return -1
+}
@@ -0,0 +1,586 @@
+/*
+Package lsp implements a language server for templates checked by
+tmplcheck. It speaks the Language Server Protocol over a pair of
+streams, typically stdin and stdout; see the lsp command of
+cmd/tmplcheck.
+
+The server publishes tmplcheck diagnostics for template and go files
+as they change, and provides hover, completion and go-to-definition in
+templates. The type of dot at the root of a template is the type of the
+data passed to it in Execute calls in the package.
+*/
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/loader"
+
+	"github.com/go-web-framework/tmplcheck"
+)
+
+// Serve runs a language server that reads requests from r and writes
+// responses to w until the client sends the exit notification, r is
+// closed or ctx is done. cfg.Templates and cfg.Package or cfg.Packages
+// are required; cfg.Overlay is ignored, since the open documents are
+// used instead.
+func Serve(ctx context.Context, r io.Reader, w io.Writer, cfg *tmplcheck.Config) error {
+	if cfg.Package == "" && len(cfg.Packages) == 0 || cfg.Templates == "" {
+		return errors.New("lsp: package import path and templates path are required")
+	}
+	root, err := filepath.Abs(cfg.Templates)
+	if err != nil {
+		return err
+	}
+
+	s := &server{
+		conn:      newConn(r, w),
+		cfg:       *cfg,
+		docs:      make(map[string]string),
+		published: make(map[string]bool),
+	}
+	s.cfg.Templates = root
+	if s.cfg.LeftDelim == "" {
+		s.cfg.LeftDelim = "{{"
+	}
+	if s.cfg.RightDelim == "" {
+		s.cfg.RightDelim = "}}"
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if re, ok := err.(*responseError); ok {
+			if err := s.conn.reply(nil, nil, re); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if m.Method == "exit" {
+			return nil
+		}
+		if m.Method == "" {
+			continue // response to a request of the server
+		}
+		if err := s.handle(ctx, m); err != nil {
+			return err
+		}
+	}
+}
+
+type server struct {
+	conn *conn
+	cfg  tmplcheck.Config // Templates is absolute
+
+	docs     map[string]string // path -> contents of open documents
+	shutdown bool
+
+	watch  bool // whether the client supports registering file watchers
+	lastID int  // ID of the last request sent to the client
+
+	// The loaded packages and the usages found in them, set by reload.
+	prog         *loader.Program
+	usages       map[string][]tmplcheck.Usage
+	unverifiable []tmplcheck.UnverifiableUsage
+	unattached   []tmplcheck.UnusedSuppression
+
+	published map[string]bool // URIs with diagnostics
+}
+
+// handle handles a request or notification. The returned error is
+// non-nil only if the connection failed; errors handling requests are
+// sent to the client.
+func (s *server) handle(ctx context.Context, m *message) error {
+	if s.shutdown && m.ID != nil {
+		return s.conn.reply(m.ID, nil, &responseError{codeInvalidParams, "server is shut down"})
+	}
+
+	switch m.Method {
+	case "initialize":
+		var p initializeParams
+		if err := json.Unmarshal(m.Params, &p); err == nil {
+			s.watch = p.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+		}
+		return s.conn.reply(m.ID, initializeResult, nil)
+	case "initialized":
+		if s.watch {
+			if err := s.registerWatchers(); err != nil {
+				return err
+			}
+		}
+		return s.reload(ctx)
+	case "shutdown":
+		s.shutdown = true
+		return s.conn.reply(m.ID, nil, nil)
+
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			return nil
+		}
+		return s.didChange(ctx, p.TextDocument.URI, &p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := json.Unmarshal(m.Params, &p); err != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		// The server only supports full document sync, so the last
+		// change is the whole document.
+		text := p.ContentChanges[len(p.ContentChanges)-1].Text
+		return s.didChange(ctx, p.TextDocument.URI, &text)
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			return nil
+		}
+		return s.didChange(ctx, p.TextDocument.URI, nil)
+	case "textDocument/didSave":
+		// Open documents are already up to date.
+		return nil
+	case "workspace/didChangeWatchedFiles":
+		var p didChangeWatchedFilesParams
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			return nil
+		}
+		for _, c := range p.Changes {
+			if strings.HasSuffix(c.URI, ".go") {
+				return s.reload(ctx)
+			}
+		}
+		return s.check(ctx)
+
+	case "textDocument/hover":
+		var p textDocumentPositionParams
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			return s.conn.reply(m.ID, nil, &responseError{codeInvalidParams, err.Error()})
+		}
+		res, err := s.hover(p)
+		return s.conn.reply(m.ID, res, err)
+	case "textDocument/completion":
+		var p textDocumentPositionParams
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			return s.conn.reply(m.ID, nil, &responseError{codeInvalidParams, err.Error()})
+		}
+		res, err := s.completion(p)
+		return s.conn.reply(m.ID, res, err)
+	case "textDocument/definition":
+		var p textDocumentPositionParams
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			return s.conn.reply(m.ID, nil, &responseError{codeInvalidParams, err.Error()})
+		}
+		res, err := s.definition(p)
+		return s.conn.reply(m.ID, res, err)
+	}
+
+	if m.ID != nil {
+		return s.conn.reply(m.ID, nil, &responseError{codeMethodNotFound, "method not supported: " + m.Method})
+	}
+	return nil // unknown notifications are ignored
+}
+
+var initializeResult = map[string]interface{}{
+	"capabilities": map[string]interface{}{
+		"textDocumentSync": map[string]interface{}{
+			"openClose": true,
+			"change":    1, // full
+		},
+		"hoverProvider": true,
+		"completionProvider": map[string]interface{}{
+			"triggerCharacters": []string{"."},
+		},
+		"definitionProvider": true,
+	},
+	"serverInfo": map[string]string{"name": "tmplcheck"},
+}
+
+// registerWatchers asks the client to notify the server of changes to
+// go files and templates that are not open, such as those made by
+// checking out another branch, with workspace/didChangeWatchedFiles.
+func (s *server) registerWatchers() error {
+	root := filepath.ToSlash(s.cfg.Templates)
+	watchers := []fileSystemWatcher{{GlobPattern: "**/*.go"}}
+	for _, pat := range s.cfg.Include {
+		watchers = append(watchers, fileSystemWatcher{GlobPattern: root + "/" + pat})
+	}
+	if len(s.cfg.Include) == 0 {
+		watchers = append(watchers, fileSystemWatcher{GlobPattern: root + "/**/*"})
+	}
+
+	s.lastID++
+	return s.conn.request(s.lastID, "client/registerCapability", registrationParams{
+		Registrations: []registration{{
+			ID:              "tmplcheck/watchedFiles",
+			Method:          "workspace/didChangeWatchedFiles",
+			RegisterOptions: didChangeWatchedFilesRegistrationOptions{Watchers: watchers},
+		}},
+	})
+}
+
+// didChange records the contents of the document at uri, or that it
+// was closed if text is nil, and checks again.
+func (s *server) didChange(ctx context.Context, uri string, text *string) error {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return nil
+	}
+	if text == nil {
+		delete(s.docs, path)
+	} else {
+		s.docs[path] = *text
+	}
+
+	if filepath.Ext(path) == ".go" {
+		return s.reload(ctx)
+	}
+	if _, ok := s.templatePath(path); ok {
+		return s.check(ctx)
+	}
+	return nil
+}
+
+// templatePath returns the path of the file relative to the templates
+// directory, and whether the file is in it.
+func (s *server) templatePath(path string) (string, bool) {
+	rel, err := filepath.Rel(s.cfg.Templates, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// reload loads the packages, using the contents of open go documents,
+// and checks again.
+func (s *server) reload(ctx context.Context) error {
+	ctxt := build.Default
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		if text, ok := s.docs[path]; ok {
+			return ioutil.NopCloser(strings.NewReader(text)), nil
+		}
+		return os.Open(path)
+	}
+
+	conf := loader.Config{
+		Build:       &ctxt,
+		ParserMode:  parser.ParseComments, // for doc comments in hover
+		AllowErrors: true,
+		TypeChecker: types.Config{Error: func(error) {}},
+	}
+	paths := s.cfg.ImportPaths()
+	for _, path := range paths {
+		conf.Import(path)
+	}
+	prog, err := conf.Load()
+	if err != nil {
+		return s.showError(err)
+	}
+
+	usages := make(map[string][]tmplcheck.Usage)
+	var unverifiable []tmplcheck.UnverifiableUsage
+	var unattached []tmplcheck.UnusedSuppression
+	for _, path := range paths {
+		pkg := prog.Package(path)
+		if pkg == nil {
+			return s.showError(fmt.Errorf("package %q was not loaded", path))
+		}
+		u, uv, us := tmplcheck.FindUsages(prog.Fset, pkg.Files, &pkg.Info)
+		for name, v := range u {
+			usages[name] = append(usages[name], v...)
+		}
+		unverifiable = append(unverifiable, uv...)
+		unattached = append(unattached, us...)
+	}
+
+	s.prog, s.usages, s.unverifiable, s.unattached = prog, usages, unverifiable, unattached
+	return s.check(ctx)
+}
+
+// showError shows the error to the user.
+func (s *server) showError(err error) error {
+	return s.conn.notify("window/showMessage", map[string]interface{}{
+		"type":    1, // error
+		"message": "tmplcheck: " + err.Error(),
+	})
+}
+
+// check checks the templates against the loaded usages, using the
+// contents of open template documents, and publishes the diagnostics.
+func (s *server) check(ctx context.Context) error {
+	if s.prog == nil {
+		return nil // not loaded yet
+	}
+
+	cfg := s.cfg
+	cfg.Overlay = make(map[string][]byte)
+	for path, text := range s.docs {
+		if rel, ok := s.templatePath(path); ok {
+			cfg.Overlay[rel] = []byte(text)
+		}
+	}
+
+	res, err := tmplcheck.CheckUsages(ctx, &cfg, s.usages, s.unverifiable, s.unattached)
+	if err != nil {
+		return s.showError(err)
+	}
+	return s.publish(s.diagnostics(res))
+}
+
+// publish publishes the diagnostics, keyed by URI, and clears the
+// diagnostics previously published for other URIs.
+func (s *server) publish(diags map[string][]diagnostic) error {
+	for uri := range s.published {
+		if _, ok := diags[uri]; !ok {
+			diags[uri] = []diagnostic{}
+		}
+	}
+	s.published = make(map[string]bool)
+
+	for uri, d := range diags {
+		if len(d) != 0 {
+			s.published[uri] = true
+		}
+		err := s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: d})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diagnostics returns the diagnostics for the result, keyed by URI.
+func (s *server) diagnostics(res *tmplcheck.Result) map[string][]diagnostic {
+	ret := make(map[string][]diagnostic)
+	add := func(l location, d diagnostic) {
+		d.Range = l.Range
+		d.Source = "tmplcheck"
+		ret[l.URI] = append(ret[l.URI], d)
+	}
+
+	for _, r := range res.Templates {
+		if r.ParseErr != nil {
+			add(s.parseErrLocation(*r.ParseErr), diagnostic{
+				Severity: lspSeverity(r.ParseErr.Severity),
+				Code:     tmplcheck.RuleParseError,
+				Message:  r.ParseErr.Msg,
+			})
+		}
+
+		for _, m := range r.Missing {
+			tl := s.identLocation(m.TemplateIdent)
+			gl := s.goLocation(m.Usage)
+			add(tl, diagnostic{
+				Severity: lspSeverity(m.Severity),
+				Code:     tmplcheck.RuleMissingKey,
+				Message:  fmt.Sprintf("%q is missing from the data passed by %s.%s", m.MissingKey, m.Usage.Obj, m.Usage.Call) + tmplcheck.DidYouMean(m.Suggestions),
+				RelatedInformation: []relatedInformation{{
+					Location: gl,
+					Message:  fmt.Sprintf("%s.%s called here", m.Usage.Obj, m.Usage.Call),
+				}},
+			})
+			add(gl, diagnostic{
+				Severity: lspSeverity(m.Severity),
+				Code:     tmplcheck.RuleMissingKey,
+				Message:  fmt.Sprintf("%s.%s is missing %q, used by template %s", m.Usage.Obj, m.Usage.Call, m.MissingKey, r.Template) + tmplcheck.DidYouMean(m.Suggestions),
+				RelatedInformation: []relatedInformation{{
+					Location: tl,
+					Message:  fmt.Sprintf("%q used here", m.MissingKey),
+				}},
+			})
+		}
+
+		for _, u := range r.Unexported {
+			add(s.identLocation(u.TemplateIdent), diagnostic{
+				Severity: lspSeverity(u.Severity),
+				Code:     tmplcheck.RuleUnexportedField,
+				Message:  fmt.Sprintf("%q is an unexported field of %s", u.Field, u.Type),
+				RelatedInformation: []relatedInformation{{
+					Location: s.goLocation(u.Usage),
+					Message:  fmt.Sprintf("%s.%s called here", u.Usage.Obj, u.Usage.Call),
+				}},
+			})
+		}
+
+		for _, e := range r.FuncArgs {
+			add(s.identLocation(e.TemplateIdent), diagnostic{
+				Severity: lspSeverity(e.Severity),
+				Code:     tmplcheck.RuleBadFuncArgs,
+				Message:  fmt.Sprintf("wrong number of arguments for %s: want %s, got %d", e.Func, e.Want, e.Got),
+			})
+		}
+
+		for _, u := range r.UnusedKeys {
+			add(s.goLocation(u.Usage), diagnostic{
+				Severity: lspSeverity(u.Severity),
+				Code:     tmplcheck.RuleUnusedKey,
+				Message:  fmt.Sprintf("%q is not used by template %s", u.Key, r.Template),
+			})
+		}
+
+		for _, u := range r.Unknown {
+			add(s.goLocation(u.Usage), diagnostic{
+				Severity: lspSeverity(u.Severity),
+				Code:     tmplcheck.RuleUnknownTemplate,
+				Message:  fmt.Sprintf("unknown template %q", u.Usage.Template) + tmplcheck.DidYouMean(u.Suggestions),
+			})
+		}
+
+		for _, u := range r.Unverifiable {
+			add(s.goLocation(u.Usage), diagnostic{
+				Severity: lspSeverity(u.Severity),
+				Code:     tmplcheck.RuleUnverifiable,
+				Message:  fmt.Sprintf("%s.%s cannot be verified: %s", u.Usage.Obj, u.Usage.Call, u.Reason),
+			})
+		}
+
+		for _, u := range r.Unused {
+			msg := "unused tmplcheck:ignore directive"
+			if rule := u.Suppression.Rule; rule != "" && tmplcheck.LookupRule(rule) == nil {
+				msg = fmt.Sprintf("unknown rule %q in tmplcheck:ignore directive", rule)
+			}
+			add(s.suppressionLocation(u), diagnostic{
+				Severity: lspSeverity(u.Severity),
+				Code:     tmplcheck.RuleUnusedSuppression,
+				Message:  msg,
+			})
+		}
+	}
+	return ret
+}
+
+// lspSeverity returns the diagnostic severity for the severity.
+func lspSeverity(sev tmplcheck.Severity) int {
+	switch sev {
+	case tmplcheck.SeverityError:
+		return severityError
+	case tmplcheck.SeverityWarning:
+		return severityWarning
+	}
+	return severityInformation
+}
+
+// text returns the contents of the file at path: the open document if
+// any, or the file on disk. It is empty if the file cannot be read.
+func (s *server) text(path string) string {
+	if text, ok := s.docs[path]; ok {
+		return text
+	}
+	b, _ := ioutil.ReadFile(path)
+	return string(b)
+}
+
+// identLocation returns the location of the identifiers in the
+// template, such as .User.Email. If their end is unknown, it is that of
+// the chain around their position.
+func (s *server) identLocation(ti tmplcheck.TemplateIdent) location {
+	path := filepath.Join(s.cfg.Templates, ti.Path)
+	text := s.text(path)
+	start, end := int(ti.Pos), int(ti.End)
+	if end <= start || end > len(text) {
+		start, end = chainBounds(text, start)
+	}
+	return location{
+		URI:   pathToURI(path),
+		Range: span{toPosition(text, start), toPosition(text, end)},
+	}
+}
+
+// parseErrLocation returns the location of the parse error, which is
+// the rest of the line from the column if known.
+func (s *server) parseErrLocation(pe tmplcheck.ParseError) location {
+	return s.lineLocation(filepath.Join(s.cfg.Templates, pe.Path), pe.Line, pe.Col)
+}
+
+// suppressionLocation returns the location of the unused directive, in
+// the template or in go source.
+func (s *server) suppressionLocation(u tmplcheck.UnusedSuppression) location {
+	path := filepath.Join(s.cfg.Templates, u.Suppression.Path)
+	if u.Usage != nil {
+		path = s.prog.Fset.Position(u.Usage.Pos).Filename
+	}
+	return s.lineLocation(path, u.Suppression.Line, u.Suppression.Col)
+}
+
+// lineLocation returns the location of the rest of the line in the
+// file from the column, in characters, if known.
+func (s *server) lineLocation(path string, line, col int) location {
+	text := s.text(path)
+
+	start := 0
+	for l := 1; l < line; l++ {
+		i := indexByteFrom(text, '\n', start)
+		if i < 0 {
+			break
+		}
+		start = i + 1
+	}
+	end := indexByteFrom(text, '\n', start)
+	if end < 0 {
+		end = len(text)
+	}
+	if start == 0 && strings.HasPrefix(text, "\ufeff") {
+		start = len("\ufeff")
+	}
+	for n := 1; n < col && start < end; n++ {
+		_, size := utf8.DecodeRuneInString(text[start:end])
+		start += size
+	}
+	return location{
+		URI:   pathToURI(path),
+		Range: span{toPosition(text, start), toPosition(text, end)},
+	}
+}
+
+// goLocation returns the location of the called method of the usage,
+// such as s.Execute.
+func (s *server) goLocation(u tmplcheck.Usage) location {
+	p := s.prog.Fset.Position(u.Pos)
+	text := s.text(p.Filename)
+	end := p.Offset + len(u.Obj) + 1 + len(u.Call)
+	return location{
+		URI:   pathToURI(p.Filename),
+		Range: span{toPosition(text, p.Offset), toPosition(text, end)},
+	}
+}
+
+// chainBounds returns the offsets of the start and end of the chain
+// of identifiers, fields and variables around offset i in text.
+func chainBounds(text string, i int) (start, end int) {
+	if i > len(text) {
+		i = len(text)
+	}
+	start, end = i, i
+	for start > 0 && isChainByte(text[start-1]) {
+		start--
+	}
+	for end < len(text) && isChainByte(text[end]) {
+		end++
+	}
+	return start, end
+}
+
+func isChainByte(c byte) bool {
+	return c == '.' || c == '$' || isIdentByte(c)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c >= 0x80
+}
@@ -0,0 +1,367 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+	"strings"
+
+	htemplate "html/template"
+	"text/template/parse"
+
+	"golang.org/x/tools/go/types/typeutil"
+
+	"github.com/go-web-framework/tmplcheck/visit"
+)
+
+// packageName qualifies types by package name, as in source code.
+func packageName(p *types.Package) string {
+	return p.Name()
+}
+
+// errFound stops a walk once the node of interest is found.
+var errFound = errors.New("found")
+
+// segment is a name in a field chain, such as User or Email in
+// {{.User.Email}}, or the variable in {{$user.Email}}.
+type segment struct {
+	start, end int // byte offsets in the template
+	name       string
+
+	typ types.Type   // type of the value; nil if unknown
+	obj types.Object // field or method; nil for variables and map values
+
+	decl *parse.VariableNode // declaration of the variable, if a variable
+}
+
+// document returns the path, relative to the templates directory, and
+// the contents of the template at uri.
+func (s *server) document(uri string) (rel, text string, err error) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return "", "", err
+	}
+	rel, ok := s.templatePath(path)
+	if !ok {
+		return "", "", fmt.Errorf("lsp: %s is not in the templates directory", path)
+	}
+	return rel, s.text(path), nil
+}
+
+// dataType returns the type of the data passed to the template in
+// Execute calls, or nil if unknown.
+func (s *server) dataType(rel string) types.Type {
+	for _, u := range s.usages[rel] {
+		if u.Data == nil || u.Data == types.Typ[types.UntypedNil] {
+			continue
+		}
+		return u.Data
+	}
+	return nil
+}
+
+// walk parses the template and walks its trees, including those of
+// {{define}} and {{block}}, until f returns true. Dot at the root of
+// the template is the type of the data passed to it; it is unknown in
+// other trees.
+func (s *server) walk(rel, text string, f func(n parse.Node, sc *visit.Scope) bool) error {
+	t, err := htemplate.New(rel).Delims(s.cfg.LeftDelim, s.cfg.RightDelim).Parse(text)
+	if err != nil {
+		return err
+	}
+
+	v := visit.Funcs{EnterFunc: func(n parse.Node, sc *visit.Scope) error {
+		if f(n, sc) {
+			return errFound
+		}
+		return nil
+	}}
+	for _, tt := range t.Templates() {
+		if tt.Tree == nil {
+			continue
+		}
+		var dot types.Type
+		if tt.Name() == rel {
+			dot = s.dataType(rel)
+		}
+		err := visit.Walk(tt.Tree, dot, v)
+		if err == errFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentAt returns the segment at the byte offset in the template, or
+// nil if there is none.
+func (s *server) segmentAt(rel, text string, offset int) (*segment, error) {
+	var ret *segment
+	err := s.walk(rel, text, func(n parse.Node, sc *visit.Scope) bool {
+		for _, seg := range segments(n, sc) {
+			if seg.start <= offset && offset <= seg.end {
+				seg := seg
+				ret = &seg
+				return true
+			}
+		}
+		return false
+	})
+	return ret, err
+}
+
+// segments returns the segments of a field, variable or chain node.
+func segments(n parse.Node, sc *visit.Scope) []segment {
+	var ret []segment
+	var typ types.Type
+	var names []string
+	pos := int(n.Position())
+
+	// The position of a field or variable followed by fields is the
+	// position of the first field after it, as in .User.Email and
+	// $user.Email; the position of a chain is that of its first field.
+	switch n := n.(type) {
+	case *parse.FieldNode:
+		typ, names = sc.Dot, n.Ident
+		if len(names) > 1 {
+			pos -= 1 + len(names[0])
+		}
+	case *parse.ChainNode:
+		typ, names = sc.TypeOf(n.Node), n.Field
+	case *parse.VariableNode:
+		v, ok := sc.Lookup(n.Ident[0])
+		if !ok {
+			return nil
+		}
+		typ, names = v.Type, n.Ident[1:]
+		if len(names) > 0 {
+			pos -= len(n.Ident[0])
+		}
+		end := pos + len(n.Ident[0])
+		ret = append(ret, segment{start: pos, end: end, name: n.Ident[0], typ: typ, decl: v.Decl})
+		pos = end
+	default:
+		return nil
+	}
+
+	for _, name := range names {
+		start := pos + 1 // after the dot
+		var obj types.Object
+		typ, obj = visit.Field(typ, name)
+		ret = append(ret, segment{start: start, end: start + len(name), name: name, typ: typ, obj: obj})
+		pos = start + len(name)
+	}
+	return ret
+}
+
+func (s *server) hover(p textDocumentPositionParams) (*hover, error) {
+	rel, text, err := s.document(p.TextDocument.URI)
+	if err != nil || s.prog == nil {
+		return nil, nil
+	}
+	seg, err := s.segmentAt(rel, text, toOffset(text, p.Position))
+	if err != nil || seg == nil {
+		return nil, nil
+	}
+
+	qual := packageName
+	var sig, doc string
+	switch {
+	case seg.obj != nil:
+		sig = types.ObjectString(seg.obj, qual)
+		doc = s.doc(seg.obj)
+	case seg.typ != nil:
+		sig = seg.name + " " + types.TypeString(seg.typ, qual)
+	default:
+		return nil, nil
+	}
+
+	value := "```go\n" + sig + "\n```"
+	if doc != "" {
+		value += "\n\n" + doc
+	}
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: value},
+		Range:    &span{toPosition(text, seg.start), toPosition(text, seg.end)},
+	}, nil
+}
+
+// doc returns the doc comment of the field or method, or the line
+// comment of a field without a doc comment.
+func (s *server) doc(obj types.Object) string {
+	_, path, _ := s.prog.PathEnclosingInterval(obj.Pos(), obj.Pos())
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.Field:
+			if d := n.Doc.Text(); d != "" {
+				return d
+			}
+			return n.Comment.Text()
+		case *ast.FuncDecl:
+			return n.Doc.Text()
+		}
+	}
+	return ""
+}
+
+func (s *server) definition(p textDocumentPositionParams) ([]location, error) {
+	rel, text, err := s.document(p.TextDocument.URI)
+	if err != nil || s.prog == nil {
+		return nil, nil
+	}
+	seg, err := s.segmentAt(rel, text, toOffset(text, p.Position))
+	if err != nil || seg == nil {
+		return nil, nil
+	}
+
+	switch {
+	case seg.obj != nil:
+		pos := s.prog.Fset.Position(seg.obj.Pos())
+		if !pos.IsValid() {
+			return nil, nil
+		}
+		gotext := s.text(pos.Filename)
+		return []location{{
+			URI:   pathToURI(pos.Filename),
+			Range: span{toPosition(gotext, pos.Offset), toPosition(gotext, pos.Offset+len(seg.obj.Name()))},
+		}}, nil
+	case seg.decl != nil:
+		start := int(seg.decl.Pos)
+		return []location{{
+			URI:   p.TextDocument.URI,
+			Range: span{toPosition(text, start), toPosition(text, start+len(seg.decl.Ident[0]))},
+		}}, nil
+	}
+	return nil, nil
+}
+
+func (s *server) completion(p textDocumentPositionParams) (*completionList, error) {
+	list := &completionList{Items: []completionItem{}}
+	rel, text, err := s.document(p.TextDocument.URI)
+	if err != nil || s.prog == nil {
+		return list, nil
+	}
+
+	// The cursor is in or after the name being completed, which follows
+	// a dot and the chain of names before it, as in {{.User.Em|}}.
+	offset := toOffset(text, p.Position)
+	i := offset
+	for i > 0 && isIdentByte(text[i-1]) {
+		i--
+	}
+	if i == 0 || text[i-1] != '.' {
+		return list, nil
+	}
+	start := i - 1
+	for start > 0 && isChainByte(text[start-1]) {
+		start--
+	}
+	chain := text[start : i-1]
+	end := offset
+	for end < len(text) && isIdentByte(text[end]) {
+		end++
+	}
+
+	// The template is usually not valid while the name is typed, so
+	// the chain is replaced by {{.}} to find the scope at the cursor.
+	// The right delimiter may not have been typed yet either.
+	var sc *visit.Scope
+	for _, repl := range []string{".", ". " + s.cfg.RightDelim} {
+		err := s.walk(rel, text[:start]+repl+text[end:], func(n parse.Node, scope *visit.Scope) bool {
+			if d, ok := n.(*parse.DotNode); ok && int(d.Pos) == start {
+				sc = scope.Copy()
+				return true
+			}
+			return false
+		})
+		if err == nil && sc != nil {
+			break
+		}
+	}
+	if sc == nil {
+		return list, nil
+	}
+
+	var typ types.Type
+	switch {
+	case chain == "":
+		typ = sc.Dot
+	case chain[0] == '$':
+		names := strings.Split(chain, ".")
+		v, ok := sc.Lookup(names[0])
+		if !ok {
+			return list, nil
+		}
+		typ = visit.FieldChain(v.Type, names[1:])
+	case chain[0] == '.':
+		typ = visit.FieldChain(sc.Dot, strings.Split(chain[1:], "."))
+	default:
+		return list, nil
+	}
+
+	list.Items = s.members(rel, typ)
+	return list, nil
+}
+
+// members returns the completion items for the fields and methods of a
+// value of type t. For the data passed to the template, which is often
+// a map, the keys passed in Execute calls are also included.
+func (s *server) members(rel string, t types.Type) []completionItem {
+	ret := []completionItem{}
+	if t == nil {
+		return ret
+	}
+	qual := packageName
+	seen := make(map[string]bool)
+
+	if data := s.dataType(rel); data != nil && types.Identical(t, data) {
+		for _, u := range s.usages[rel] {
+			for _, k := range u.Keys {
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+				typ, _ := visit.Field(t, k)
+				item := completionItem{Label: k, Kind: completionProperty}
+				if typ != nil {
+					item.Detail = types.TypeString(typ, qual)
+				}
+				ret = append(ret, item)
+			}
+		}
+	}
+
+	for _, f := range visit.Fields(t) {
+		if seen[f.Name()] {
+			continue
+		}
+		seen[f.Name()] = true
+		ret = append(ret, completionItem{
+			Label:         f.Name(),
+			Kind:          completionField,
+			Detail:        types.TypeString(f.Type(), qual),
+			Documentation: s.doc(f),
+		})
+	}
+
+	for _, sel := range typeutil.IntuitiveMethodSet(t, nil) {
+		m := sel.Obj()
+		if !m.Exported() || seen[m.Name()] {
+			continue
+		}
+		seen[m.Name()] = true
+		ret = append(ret, completionItem{
+			Label:         m.Name(),
+			Kind:          completionMethod,
+			Detail:        types.ObjectString(m, qual),
+			Documentation: s.doc(m),
+		})
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Label < ret[j].Label })
+	return ret
+}
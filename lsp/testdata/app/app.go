@@ -0,0 +1,27 @@
+package app
+
+import (
+	"io"
+
+	"github.com/go-web-framework/templates"
+)
+
+type User struct {
+	// Email is the primary email address of the user.
+	Email string
+	Name  string // full name
+}
+
+// Initials returns the initials of the user's name.
+func (u User) Initials() string {
+	return ""
+}
+
+type page struct {
+	Title string
+	User  User
+}
+
+func Render(set *templates.Set, w io.Writer) error {
+	return set.Execute("index.html", w, page{Title: "Home", User: User{}})
+}
@@ -0,0 +1,110 @@
+package tmplcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// A Reporter writes results in an output format.
+type Reporter interface {
+	Report(w io.Writer, res *Result) error
+}
+
+// ReporterFunc is a function that is a Reporter.
+type ReporterFunc func(w io.Writer, res *Result) error
+
+// Report calls f(w, res).
+func (f ReporterFunc) Report(w io.Writer, res *Result) error {
+	return f(w, res)
+}
+
+// Formats are the output formats supported by Output, in the order they
+// were registered.
+var Formats []string
+
+var reporters = make(map[string]Reporter)
+
+func init() {
+	RegisterReporter("plain", &PlainReporter{})
+	RegisterReporter("json", ReporterFunc(writeJSON))
+	RegisterReporter("sarif", ReporterFunc(writeSARIF))
+	RegisterReporter("junit", ReporterFunc(writeJUnit))
+	RegisterReporter("checkstyle", ReporterFunc(writeCheckstyle))
+	RegisterReporter("html", ReporterFunc(writeHTML))
+	RegisterReporter("gitlab", ReporterFunc(writeGitLab))
+	RegisterReporter("github", ReporterFunc(writeGitHub))
+}
+
+// RegisterReporter makes the reporter available to Output as the format,
+// replacing the reporter of the format if there is one. It is meant to
+// be called from init functions.
+func RegisterReporter(format string, r Reporter) {
+	if _, ok := reporters[format]; !ok {
+		Formats = append(Formats, format)
+	}
+	reporters[format] = r
+}
+
+// Output writes the result to w in the format, which is one of Formats.
+func Output(w io.Writer, format string, res *Result) error {
+	r, ok := reporters[format]
+	if !ok {
+		return fmt.Errorf("tmplcheck: unsupported output format: %q", format)
+	}
+	return r.Report(w, res)
+}
+
+func writeJSON(w io.Writer, res *Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	// The JSON output is a list of templates. Encode the slice
+	// itself so that no templates encodes as [] rather than null.
+	results := res.Templates
+	if results == nil {
+		results = []TemplateResult{}
+	}
+	return enc.Encode(results)
+}
+
+// displayName returns the path of the template, including Root, or a
+// placeholder if the template is unknown.
+func (r *TemplateResult) displayName() string {
+	name := r.Template
+	if name == "" {
+		name = "<unknown template>"
+	}
+	return filepath.ToSlash(filepath.Join(r.Root, name))
+}
+
+// templatePath returns the path of the template file at the path
+// relative to the templates directory.
+func (r *TemplateResult) templatePath(path string) string {
+	return filepath.Join(r.Dir, path)
+}
+
+// filePaths returns the paths of the template file and of the go source
+// file of the finding, relative to base if they are in it, or "" for
+// positions that are not valid.
+func (r *TemplateResult) filePaths(base string, f Finding) (tmpl, goFile string) {
+	if f.Template.IsValid() {
+		tmpl = outputPath(base, r.templatePath(f.Template.Path))
+	}
+	if f.Go.IsValid() && f.Usage != nil {
+		goFile = outputPath(base, f.Usage.Filename)
+	}
+	return tmpl, goFile
+}
+
+// outputPath returns path with forward slashes, relative to base if it
+// is in it, or absolute.
+func outputPath(base, path string) string {
+	if rel, ok := relPath(base, path); ok {
+		return filepath.ToSlash(rel)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return filepath.ToSlash(path)
+}
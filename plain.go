@@ -0,0 +1,178 @@
+package tmplcheck
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PlainReporter writes findings in the plain output format: one line
+// per finding, in the style of compilers, such as
+//
+//	templates/page.html:5:13: "Title" is missing from the data passed by set.Execute (main.go:20) [TC001]
+//
+// so that editors and terminals can open the file at the position.
+// Paths are relative to the working directory if they are in it.
+type PlainReporter struct {
+	Color bool // color the output with ANSI escape sequences
+
+	// Snippets enables printing the source line of each finding with a
+	// caret under the column, and Context lines before and after it,
+	// followed by the line of the Execute call if any.
+	Snippets bool
+	Context  int
+}
+
+// ANSI escape sequences used by PlainReporter.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiCyan   = "\x1b[36m"
+)
+
+var severityColors = [...]string{
+	SeverityInfo:    ansiCyan,
+	SeverityWarning: ansiYellow,
+	SeverityError:   ansiRed,
+}
+
+// Report writes the findings of the result.
+func (p *PlainReporter) Report(w io.Writer, res *Result) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	files := make(fileCache)
+	for i := range res.Templates {
+		r := &res.Templates[i]
+		for _, f := range r.Findings() {
+			p.writeFinding(bw, wd, files, r, f)
+		}
+	}
+	return bw.Flush()
+}
+
+func (p *PlainReporter) writeFinding(w *bufio.Writer, wd string, files fileCache, r *TemplateResult, f Finding) {
+	tmpl, goFile := r.filePaths(wd, f)
+	var where, call string
+	switch {
+	case tmpl != "":
+		where = positionString(tmpl, f.Template)
+		if goFile != "" {
+			call = positionString(goFile, f.Go)
+		}
+	case goFile != "":
+		where = positionString(goFile, f.Go)
+	default:
+		where = r.displayName()
+	}
+
+	fmt.Fprintf(w, "%s: ", p.color(ansiBold, where))
+	if label := f.Severity.label(); label != "" {
+		fmt.Fprint(w, p.color(severityColors[f.Severity], label))
+	}
+	fmt.Fprint(w, f.Message)
+	if call != "" {
+		fmt.Fprintf(w, " (%s)", call)
+	}
+	fmt.Fprintf(w, " %s\n", p.color(ansiDim, "["+f.Rule.ID+"]"))
+	for _, pos := range f.OtherTemplate {
+		fmt.Fprintf(w, "\t%s: also used here\n", positionString(outputPath(wd, r.templatePath(pos.Path)), pos))
+	}
+	for _, u := range f.OtherUsages {
+		fmt.Fprintf(w, "\t%s: also not passed by %s.%s\n", positionString(outputPath(wd, u.Filename), usagePosition(u)), u.Obj, u.Call)
+	}
+
+	if !p.Snippets {
+		return
+	}
+	if tmpl != "" && f.Template.Line > 0 {
+		p.writeSnippet(w, files.lines(r.templatePath(f.Template.Path)), f.Template, p.Context)
+	}
+	if f.Usage != nil && f.Go.Line > 0 {
+		if tmpl != "" {
+			fmt.Fprintf(w, "  %s %s\n", p.color(ansiBlue, "-->"), call)
+		}
+		p.writeSnippet(w, files.lines(f.Usage.Filename), Position{Line: f.Go.Line}, 0)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeSnippet writes the line of the position and context lines before
+// and after it, with a caret under the column if it is known, followed
+// by tildes up to the end of the range if it is on the same line.
+func (p *PlainReporter) writeSnippet(w *bufio.Writer, lines []string, pos Position, context int) {
+	if pos.Line > len(lines) {
+		return
+	}
+	first, last := pos.Line-context, pos.Line+context
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := len(strconv.Itoa(last))
+	gutter := func(s string) string {
+		return p.color(ansiBlue, fmt.Sprintf("%*s |", width+1, s))
+	}
+
+	for n := first; n <= last; n++ {
+		fmt.Fprintf(w, "%s %s\n", gutter(strconv.Itoa(n)), lines[n-1])
+		if n == pos.Line && pos.Col > 0 {
+			marker := "^"
+			if pos.EndLine == pos.Line && pos.EndCol > pos.Col+1 {
+				marker += strings.Repeat("~", pos.EndCol-pos.Col-1)
+			}
+			fmt.Fprintf(w, "%s %s%s\n", gutter(""), caretIndent(lines[n-1], pos.Col), p.color(ansiRed, marker))
+		}
+	}
+}
+
+func (p *PlainReporter) color(code, s string) string {
+	if !p.Color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// caretIndent returns the whitespace that puts a caret under the 1-based
+// column of the line, keeping tabs so that it lines up.
+func caretIndent(line string, col int) string {
+	var b strings.Builder
+	for _, c := range line[:byteOffset(line, col)] {
+		if c == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// fileCache is the lines of files read for snippets, by path. Files that
+// cannot be read have no lines.
+type fileCache map[string][]string
+
+func (c fileCache) lines(path string) []string {
+	lines, ok := c[path]
+	if !ok {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			b = bytes.TrimPrefix(b, bom)
+			b = bytes.TrimSuffix(b, []byte("\n"))
+			lines = strings.Split(strings.Replace(string(b), "\r\n", "\n", -1), "\n")
+		}
+		c[path] = lines
+	}
+	return lines
+}
@@ -0,0 +1,193 @@
+package tmplcheck
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	tparse "text/template/parse"
+)
+
+// Positions are reported as 1-based lines and columns, where columns
+// count characters rather than bytes. Lines end with \n or \r\n, and a
+// byte order mark at the start of a file is not part of the first line.
+
+// bom is the UTF-8 byte order mark.
+var bom = []byte("\xef\xbb\xbf")
+
+// lineIndex maps byte offsets in a file to lines and columns. It is
+// built once per file so that positions are found without scanning the
+// file again.
+type lineIndex struct {
+	src   []byte
+	lines []int // byte offset of the start of each line
+}
+
+func newLineIndex(src []byte) *lineIndex {
+	x := &lineIndex{src: src, lines: []int{0}}
+	if bytes.HasPrefix(src, bom) {
+		x.lines[0] = len(bom)
+	}
+	for i, c := range src {
+		if c == '\n' {
+			x.lines = append(x.lines, i+1)
+		}
+	}
+	return x
+}
+
+// position returns the line and column of the byte offset.
+func (x *lineIndex) position(off int) (line, col int) {
+	if off > len(x.src) {
+		off = len(x.src)
+	}
+	i := sort.Search(len(x.lines), func(i int) bool { return x.lines[i] > off }) - 1
+	if i < 0 {
+		i = 0
+	}
+	start := x.lines[i]
+	if off < start {
+		off = start // in the byte order mark
+	}
+	return i + 1, utf8.RuneCount(x.src[start:off]) + 1
+}
+
+// offset returns the byte offset of the line and the 0-based byte
+// column in it, such as in errors of package text/template.
+func (x *lineIndex) offset(line, byteCol int) int {
+	if line < 1 || line > len(x.lines) {
+		return len(x.src)
+	}
+	off := x.lines[line-1] + byteCol
+	if off > len(x.src) {
+		off = len(x.src)
+	}
+	return off
+}
+
+// text returns the text of the line, without the line ending.
+func (x *lineIndex) text(line int) string {
+	if line < 1 || line > len(x.lines) {
+		return ""
+	}
+	start, end := x.lines[line-1], len(x.src)
+	if line < len(x.lines) {
+		end = x.lines[line] - 1
+	}
+	return strings.TrimSuffix(string(x.src[start:end]), "\r")
+}
+
+// byteOffset returns the byte offset in the line of the column, or the
+// length of the line if it is shorter.
+func byteOffset(line string, col int) int {
+	off := 0
+	for n := 1; n < col && off < len(line); n++ {
+		_, size := utf8.DecodeRuneInString(line[off:])
+		off += size
+	}
+	return off
+}
+
+// nodeSpan returns the byte offsets of the start and end of the text of
+// the node, which is a field, variable, chain or identifier, in src. The
+// position of fields and variables followed by fields, such as .a.b and
+// $x.a, is that of their second part.
+func nodeSpan(src []byte, node tparse.Node) (start, end int) {
+	pos := int(node.Position())
+	start = pos
+	var text string
+	switch n := node.(type) {
+	case *tparse.FieldNode:
+		text = n.String()
+		if len(n.Ident) > 1 {
+			start -= len(n.Ident[0]) + 1
+		}
+	case *tparse.VariableNode:
+		text = n.String()
+		if len(n.Ident) > 1 {
+			start -= len(n.Ident[0])
+		}
+	case *tparse.ChainNode:
+		text = "." + strings.Join(n.Field, ".")
+	case *tparse.IdentifierNode:
+		text = n.Ident
+	}
+	if start < 0 || start > len(src) || !bytes.HasPrefix(src[start:], []byte(text)) {
+		return pos, pos // unexpected positions
+	}
+	return start, start + len(text)
+}
+
+// goFile is the position information of a go source file.
+type goFile struct {
+	file  *token.File
+	path  string     // see Usage.Path
+	index *lineIndex // nil if the source cannot be read
+}
+
+func newGoFile(fset *token.FileSet, f *ast.File) *goFile {
+	file := fset.File(f.Pos())
+	g := &goFile{file: file, path: modulePath(file.Name())}
+	if src, err := ioutil.ReadFile(file.Name()); err == nil && len(src) == file.Size() {
+		g.index = newLineIndex(src)
+	}
+	return g
+}
+
+// position returns the line and column of pos, ignoring //line
+// directives.
+func (g *goFile) position(pos token.Pos) (line, col int) {
+	if g.index != nil {
+		return g.index.position(g.file.Offset(pos))
+	}
+	p := g.file.PositionFor(pos, false)
+	return p.Line, p.Column
+}
+
+// startsLine reports whether only spaces are before the column of the
+// line, or false if the source cannot be read.
+func (g *goFile) startsLine(line, col int) bool {
+	if g.index == nil {
+		return false
+	}
+	text := g.index.text(line)
+	return strings.TrimSpace(text[:byteOffset(text, col)]) == ""
+}
+
+// modulePath returns the path of the go source file relative to the root
+// of its module, which is the closest directory with a go.mod file, or
+// else relative to the src directory of its GOPATH workspace, with
+// forward slashes, so that it does not depend on where the code is
+// checked out. Otherwise, it returns the file name as it is.
+func modulePath(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return filename
+	}
+	for dir := filepath.Dir(abs); ; {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			if rel, err := filepath.Rel(dir, abs); err == nil {
+				return filepath.ToSlash(rel)
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		src := filepath.Join(gopath, "src")
+		if rel, err := filepath.Rel(src, abs); err == nil && !escapes(rel) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filename
+}
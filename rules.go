@@ -0,0 +1,195 @@
+package tmplcheck
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule is a kind of finding. Rules are referred to by ID, such as
+// TC001, or by name, such as missing-key, in Config, in tmplcheck:ignore
+// directives and in baselines.
+type Rule struct {
+	ID      string   // stable identifier, such as "TC001"
+	Name    string   // such as "missing-key"
+	Default Severity // severity unless set in Config
+	Summary string   // one line description
+	Doc     string   // longer description
+
+	// Bad is an example that is reported, and Good is the example
+	// fixed. Both are go source followed by a template.
+	Bad, Good string
+}
+
+// Rule IDs.
+const (
+	RuleMissingKey        = "TC001" // a key used in a template is not passed in an Execute call
+	RuleUnusedKey         = "TC002" // a key passed in an Execute call is not used in the template
+	RuleUnknownTemplate   = "TC003" // an Execute call executes a template that does not exist
+	RuleUnexportedField   = "TC004" // a template uses an unexported field of the data
+	RuleBadFuncArgs       = "TC005" // a predefined function is called with the wrong number of arguments
+	RuleParseError        = "TC006" // a template cannot be parsed
+	RuleUnverifiable      = "TC007" // an Execute call cannot be analyzed
+	RuleUnusedSuppression = "TC008" // a tmplcheck:ignore directive suppresses nothing
+)
+
+// Rules is the catalog of rules, by ID.
+var Rules = []*Rule{
+	{
+		ID:      RuleMissingKey,
+		Name:    "missing-key",
+		Default: SeverityError,
+		Summary: "a key used in a template is not passed in an Execute call",
+		Doc: `The template uses a key that is not in the data passed by an Execute
+call. The key evaluates to no value, or to an error for structs, when the
+template is executed. Every key used in a template must be passed by every
+call that executes the template. Methods with a result of the data are
+always passed, and the keys after the first one of a chain, such as Name
+in .Author.Name, are passed if they are fields, or methods with a result,
+of the type of the value before them.`,
+		Bad: `set.Execute("page.html", w, map[string]interface{}{"Name": name})
+
+<h1>{{.Title}}</h1>`,
+		Good: `set.Execute("page.html", w, map[string]interface{}{"Title": title})
+
+<h1>{{.Title}}</h1>`,
+	},
+	{
+		ID:      RuleUnusedKey,
+		Name:    "unused-key",
+		Default: SeverityInfo,
+		Summary: "a key passed in an Execute call is not used in the template",
+		Doc: `The data passed by an Execute call has a key that the template does not
+use, which is often left over from a removed part of the template or is
+a misspelling. Templates that execute other templates with data, or that
+use dot as a whole, are not checked, since they may use any key.`,
+		Bad: `set.Execute("page.html", w, map[string]interface{}{"Title": title, "Tilte": title})
+
+<h1>{{.Title}}</h1>`,
+		Good: `set.Execute("page.html", w, map[string]interface{}{"Title": title})
+
+<h1>{{.Title}}</h1>`,
+	},
+	{
+		ID:      RuleUnknownTemplate,
+		Name:    "unknown-template",
+		Default: SeverityError,
+		Summary: "an Execute call executes a template that does not exist",
+		Doc: `The template named in an Execute call is not a file in the templates
+directory, so executing it fails at run time.`,
+		Bad: `set.Execute("pgae.html", w, data)
+
+templates/page.html`,
+		Good: `set.Execute("page.html", w, data)
+
+templates/page.html`,
+	},
+	{
+		ID:      RuleUnexportedField,
+		Name:    "unexported-field",
+		Default: SeverityError,
+		Summary: "a template uses an unexported field of the data",
+		Doc: `Templates can only use the exported fields and methods of structs. Using
+an unexported one is an error when the template is executed.`,
+		Bad: `type page struct{ title string }
+set.Execute("page.html", w, page{title: t})
+
+<h1>{{.title}}</h1>`,
+		Good: `type page struct{ Title string }
+set.Execute("page.html", w, page{Title: t})
+
+<h1>{{.Title}}</h1>`,
+	},
+	{
+		ID:      RuleBadFuncArgs,
+		Name:    "bad-func-args",
+		Default: SeverityError,
+		Summary: "a predefined function is called with the wrong number of arguments",
+		Doc: `A predefined function of text/template, such as len or index, is called
+with too few or too many arguments, counting the value piped into it.
+This is not detected when the template is parsed, but is an error when
+it is executed.`,
+		Bad: `{{len .Items .Users}}
+{{.Items | index}}`,
+		Good: `{{len .Items}}
+{{index .Items 0}}`,
+	},
+	{
+		ID:      RuleParseError,
+		Name:    "parse-error",
+		Default: SeverityError,
+		Summary: "a template cannot be parsed",
+		Doc: `The template has a syntax error. Templates that cannot be parsed are not
+checked against the go source.`,
+		Bad:  `{{if .Admin}}<a href="/admin">admin</a>`,
+		Good: `{{if .Admin}}<a href="/admin">admin</a>{{end}}`,
+	},
+	{
+		ID:      RuleUnverifiable,
+		Name:    "unverifiable",
+		Default: SeverityWarning,
+		Summary: "an Execute call cannot be analyzed",
+		Doc: `The template name of an Execute call is not a constant, or its data is
+not a composite literal or a variable assigned one, so the keys it passes
+cannot be found. Pass the data as a literal, or suppress the finding with
+a //tmplcheck:ignore directive explaining why the call is correct.`,
+		Bad: `set.Execute("page.html", w, buildData())`,
+		Good: `data := page{Title: title}
+set.Execute("page.html", w, data)`,
+	},
+	{
+		ID:      RuleUnusedSuppression,
+		Name:    "unused-suppression",
+		Default: SeverityWarning,
+		Summary: "a tmplcheck:ignore directive suppresses nothing",
+		Doc: `A tmplcheck:ignore directive does not suppress any finding, typically
+because the problem it suppressed was fixed, or in go source applies to
+no Execute call. Remove it so that it does not hide new problems. A
+directive whose rule is misspelled, such as TC0001, suppresses nothing
+and is reported with the unknown rule; correct the rule.`,
+		Bad: `set.Execute("page.html", w, map[string]interface{}{"Title": title}) //tmplcheck:ignore TC001
+
+<h1>{{.Title}}</h1>`,
+		Good: `set.Execute("page.html", w, map[string]interface{}{"Title": title})
+
+<h1>{{.Title}}</h1>`,
+	},
+}
+
+// LookupRule returns the rule with the ID or name, or nil if there is
+// none. IDs are case-insensitive.
+func LookupRule(idOrName string) *Rule {
+	for _, r := range Rules {
+		if strings.EqualFold(r.ID, idOrName) || r.Name == idOrName {
+			return r
+		}
+	}
+	return nil
+}
+
+// ruleByID returns the rule with the ID, which must exist.
+func ruleByID(id string) *Rule {
+	r := LookupRule(id)
+	if r == nil {
+		panic("tmplcheck: unknown rule ID " + id)
+	}
+	return r
+}
+
+// Explain returns the documentation of the rule, with its examples.
+func (r *Rule) Explain() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s: %s\n\n", r.ID, r.Name, r.Summary)
+	fmt.Fprintf(&b, "%s\n\nDefault severity: %s\n\n", r.Doc, r.Default)
+	fmt.Fprintf(&b, "Bad:\n\n%s\n\nGood:\n\n%s\n", indent(r.Bad), indent(r.Good))
+	return b.String()
+}
+
+func indent(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = "\t" + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,281 @@
+package tmplcheck
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The sarif output format is SARIF 2.1.0, for code scanning services.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+// Only the properties used by tmplcheck are defined.
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	// sarifBaseID is the base of relative URIs, which is Result.Base.
+	sarifBaseID = "SRCROOT"
+
+	// sarifFingerprint is the key of the fingerprints in results. The
+	// version changes if Fingerprint changes.
+	sarifFingerprint = "tmplcheck/v1"
+
+	// sarifGoLocation is the ID of the related location of the Execute
+	// call.
+	sarifGoLocation = 1
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+	ColumnKind         string                           `json:"columnKind"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      sarifMessage       `json:"fullDescription"`
+	Help                 sarifMessage       `json:"help"`
+	HelpURI              string             `json:"helpUri"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations,omitempty"`
+	RelatedLocations    []sarifLocation   `json:"relatedLocations,omitempty"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type sarifLocation struct {
+	ID               int                   `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+// sarifLevel returns the SARIF level of the severity.
+func sarifLevel(s Severity) string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "note"
+	}
+	return "none"
+}
+
+// writeSARIF writes the result as a SARIF log with one run. Locations
+// are relative to res.Base when they are in it.
+func writeSARIF(w io.Writer, res *Result) error {
+	base, err := outputBase(res)
+	if err != nil {
+		return err
+	}
+
+	driver := sarifDriver{
+		Name:           "tmplcheck",
+		InformationURI: "https://github.com/go-web-framework/tmplcheck",
+	}
+	ruleIndex := make(map[string]int)
+	for i, r := range Rules {
+		ruleIndex[r.ID] = i
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   r.ID,
+			Name:                 r.Name,
+			ShortDescription:     sarifMessage{r.Summary},
+			FullDescription:      sarifMessage{r.Doc},
+			Help:                 sarifMessage{r.Explain()},
+			HelpURI:              "https://github.com/go-web-framework/tmplcheck#rules",
+			DefaultConfiguration: sarifConfiguration{sarifLevel(r.Default)},
+		})
+	}
+
+	results := []sarifResult{}
+	for i := range res.Templates {
+		r := &res.Templates[i]
+		for _, f := range r.Findings() {
+			var tmpl, goLoc *sarifLocation
+			if f.Template.IsValid() {
+				tmpl = sarifLocationOf(base, r.templatePath(f.Template.Path), f.Template)
+			}
+			if f.Go.IsValid() && f.Usage != nil {
+				goLoc = sarifLocationOf(base, f.Usage.Filename, f.Go)
+			}
+
+			sr := sarifResult{
+				RuleID:              f.Rule.ID,
+				RuleIndex:           ruleIndex[f.Rule.ID],
+				Level:               sarifLevel(f.Severity),
+				Message:             sarifMessage{f.Message},
+				PartialFingerprints: map[string]string{sarifFingerprint: f.Fingerprint()},
+			}
+			switch {
+			case tmpl != nil && goLoc != nil:
+				goLoc.ID = sarifGoLocation
+				goLoc.Message = &sarifMessage{f.Usage.Expr}
+				sr.Locations = []sarifLocation{*tmpl}
+				sr.RelatedLocations = []sarifLocation{*goLoc}
+			case tmpl != nil:
+				sr.Locations = []sarifLocation{*tmpl}
+			case goLoc != nil:
+				sr.Locations = []sarifLocation{*goLoc}
+			}
+			// Grouped findings are at each of their positions in the
+			// template, with all their Execute calls as related
+			// locations.
+			for _, pos := range f.OtherTemplate {
+				sr.Locations = append(sr.Locations, *sarifLocationOf(base, r.templatePath(pos.Path), pos))
+			}
+			for i, u := range f.OtherUsages {
+				loc := sarifLocationOf(base, u.Filename, usagePosition(u))
+				loc.ID = sarifGoLocation + 1 + i
+				loc.Message = &sarifMessage{u.Expr}
+				sr.RelatedLocations = append(sr.RelatedLocations, *loc)
+			}
+			results = append(results, sr)
+		}
+	}
+
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{driver},
+			OriginalURIBaseIDs: map[string]sarifArtifactLocation{
+				sarifBaseID: {URI: fileURI(base) + "/"},
+			},
+			Results:    results,
+			ColumnKind: "unicodeCodePoints",
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// sarifLocationOf returns the location of the position in the file at
+// path, relative to base if it is in it.
+func sarifLocationOf(base, path string, pos Position) *sarifLocation {
+	loc := &sarifLocation{}
+	if rel, ok := relPath(base, path); ok {
+		loc.PhysicalLocation.ArtifactLocation = sarifArtifactLocation{
+			URI:       (&url.URL{Path: filepath.ToSlash(rel)}).String(),
+			URIBaseID: sarifBaseID,
+		}
+	} else {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		loc.PhysicalLocation.ArtifactLocation.URI = fileURI(abs)
+	}
+	if pos.Line > 0 {
+		loc.PhysicalLocation.Region = &sarifRegion{
+			StartLine:   pos.Line,
+			StartColumn: pos.Col,
+			EndLine:     pos.EndLine,
+			EndColumn:   pos.EndCol,
+		}
+	}
+	return loc
+}
+
+// outputBase returns res.Base as an absolute path, or the working
+// directory if it is empty.
+func outputBase(res *Result) (string, error) {
+	if res.Base == "" {
+		return os.Getwd()
+	}
+	return filepath.Abs(res.Base)
+}
+
+// relPath returns path relative to base, which is absolute, and reports
+// whether path is in base. Symbolic links are resolved if path is not
+// in base otherwise.
+func relPath(base, path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	if rel, err := filepath.Rel(base, abs); err == nil && !escapes(rel) {
+		return rel, true
+	}
+	realBase, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return "", false
+	}
+	realPath, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", false
+	}
+	if rel, err := filepath.Rel(realBase, realPath); err == nil && !escapes(rel) {
+		return rel, true
+	}
+	return "", false
+}
+
+// fileURI returns the file URI of the absolute path.
+func fileURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // windows drive letter
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// escapes reports whether the relative path is outside of its base.
+func escapes(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,175 @@
+package tmplcheck
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Severity is the severity of a finding.
+type Severity int
+
+const (
+	SeverityOff     Severity = iota // not reported
+	SeverityInfo                    // informational
+	SeverityWarning                 // likely a problem
+	SeverityError                   // a problem
+)
+
+var severityNames = [...]string{
+	SeverityOff:     "off",
+	SeverityInfo:    "info",
+	SeverityWarning: "warning",
+	SeverityError:   "error",
+}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity returns the severity named s, which is one of "off",
+// "info", "warning" and "error".
+func ParseSeverity(s string) (Severity, error) {
+	for i, name := range severityNames {
+		if name == s {
+			return Severity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("tmplcheck: unknown severity: %q", s)
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(b []byte) error {
+	v, err := ParseSeverity(string(b))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// label returns the label of the severity in plain text output. Errors
+// have no label.
+func (s Severity) label() string {
+	if s == SeverityError {
+		return ""
+	}
+	return s.String() + ": "
+}
+
+// DefaultSeverity is the severity of findings for each rule ID, unless
+// set in Config.
+var DefaultSeverity = func() map[string]Severity {
+	m := make(map[string]Severity)
+	for _, r := range Rules {
+		m[r.ID] = r.Default
+	}
+	return m
+}()
+
+// Override sets the severity of findings in the templates in a
+// directory.
+type Override struct {
+	Dir      string              // directory relative to Config.Templates; "." for all templates
+	Severity map[string]Severity // severity by rule ID or name
+}
+
+// ruleSeverities returns the severities keyed by rule ID, given by
+// rule ID or name. An error is returned for unknown rules.
+func ruleSeverities(sev map[string]Severity) (map[string]Severity, error) {
+	if sev == nil {
+		return nil, nil
+	}
+	ret := make(map[string]Severity, len(sev))
+	for name, s := range sev {
+		r := LookupRule(name)
+		if r == nil {
+			return nil, fmt.Errorf("tmplcheck: unknown rule: %q", name)
+		}
+		ret[r.ID] = s
+	}
+	return ret, nil
+}
+
+// severity returns the severity of findings for the rule in the
+// template at relpath, which is empty if the template is unknown. The
+// override for the innermost directory containing the template wins;
+// for the same directory, the last override wins.
+func (c *Config) severity(rule, relpath string) Severity {
+	sev := DefaultSeverity[rule]
+	if s, ok := c.Severity[rule]; ok {
+		sev = s
+	}
+	if relpath == "" {
+		return sev
+	}
+
+	depth := -1
+	for _, o := range c.Overrides {
+		s, ok := o.Severity[rule]
+		if !ok || !inDir(relpath, o.Dir) {
+			continue
+		}
+		if d := dirDepth(o.Dir); d >= depth {
+			sev, depth = s, d
+		}
+	}
+	return sev
+}
+
+// inDir reports whether the relative path is in the relative directory.
+func inDir(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return dir == "." || strings.HasPrefix(filepath.Clean(path), dir+string(filepath.Separator))
+}
+
+func dirDepth(dir string) int {
+	dir = filepath.Clean(dir)
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, string(filepath.Separator)) + 1
+}
+
+// applySeverity sets the severity of the findings in the result and
+// removes those that are off.
+func (c *Config) applySeverity(r *TemplateResult) {
+	sev := func(rule string) Severity {
+		return c.severity(rule, r.Template)
+	}
+
+	if r.ParseErr != nil {
+		r.ParseErr.Severity = sev(RuleParseError)
+	}
+	for i := range r.Missing {
+		r.Missing[i].Severity = sev(RuleMissingKey)
+	}
+	for i := range r.UnusedKeys {
+		r.UnusedKeys[i].Severity = sev(RuleUnusedKey)
+	}
+	for i := range r.Unknown {
+		r.Unknown[i].Severity = sev(RuleUnknownTemplate)
+	}
+	for i := range r.Unexported {
+		r.Unexported[i].Severity = sev(RuleUnexportedField)
+	}
+	for i := range r.FuncArgs {
+		r.FuncArgs[i].Severity = sev(RuleBadFuncArgs)
+	}
+	for i := range r.Unverifiable {
+		r.Unverifiable[i].Severity = sev(RuleUnverifiable)
+	}
+	for i := range r.Unused {
+		r.Unused[i].Severity = sev(RuleUnusedSuppression)
+	}
+
+	r.Filter(func(f Finding) bool {
+		return f.Severity != SeverityOff
+	})
+}
@@ -0,0 +1,154 @@
+package tmplcheck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-web-framework/tmplcheck/visit"
+)
+
+// Findings about names that do not exist, such as missing keys and
+// unknown templates, suggest the names that exist and that the name may
+// be a misspelling of, such as Title for .Titel or UserName for
+// .userName.
+
+// maxSuggestions is the maximum number of suggestions of a finding.
+const maxSuggestions = 3
+
+// suggest returns the candidates that name may be a misspelling of,
+// closest first: those that differ from it only in case, then those
+// within an edit distance, ignoring case, of a third of its length.
+func suggest(name string, candidates []string) []string {
+	type match struct {
+		name  string
+		dist  int // ignoring case
+		exact int // with case
+	}
+	limit := utf8.RuneCountInString(name) / 3
+	lower := strings.ToLower(name)
+
+	var matches []match
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if c == name || seen[c] {
+			continue
+		}
+		seen[c] = true
+		if d := editDistance(lower, strings.ToLower(c)); d <= limit {
+			matches = append(matches, match{c, d, editDistance(name, c)})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case a.dist != b.dist:
+			return a.dist < b.dist
+		case a.exact != b.exact:
+			return a.exact < b.exact
+		}
+		return a.name < b.name
+	})
+
+	var ret []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		ret = append(ret, matches[i].name)
+	}
+	return ret
+}
+
+// editDistance returns the number of insertions, deletions,
+// substitutions and transpositions of adjacent characters that turn a
+// into b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// d[i][j] is the distance between s[:i] and t[:j].
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func minInt(v int, vs ...int) int {
+	for _, x := range vs {
+		if x < v {
+			v = x
+		}
+	}
+	return v
+}
+
+// keySuggestions returns the suggestions for the identifier at index i,
+// which is missing from the data passed by the usage: the keys passed by
+// the usage and the methods of the data for the first identifier, or the
+// fields and methods of the value of the identifiers before it if its
+// type is known. Only names that the usage passes are suggested, so that
+// following a suggestion fixes the finding.
+func keySuggestions(idents []string, i int, u Usage) []string {
+	candidates := append(append([]string(nil), u.Keys...), visit.Names(u.Data)...)
+	if i > 0 {
+		if t := visit.FieldChain(u.Data, idents[:i]); t != nil {
+			candidates = visit.Names(t)
+		}
+	}
+	fixed := append([]string(nil), idents...)
+	var passed []string
+	for _, c := range candidates {
+		fixed[i] = c
+		if passes(u, fixed, i) {
+			passed = append(passed, c)
+		}
+	}
+	return suggest(idents[i], passed)
+}
+
+// DidYouMean returns the suggestions for a missing key or an unknown
+// template as a question to append to the message of a finding, such as
+// `; did you mean "Title"?`, or "" if there are none. Output formats
+// that print their own messages can use it to phrase suggestions alike.
+func DidYouMean(suggestions []string) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	last := len(quoted) - 1
+	if last == 0 {
+		return "; did you mean " + quoted[0] + "?"
+	}
+	return "; did you mean " + strings.Join(quoted[:last], ", ") + " or " + quoted[last] + "?"
+}
+
+// mergeSuggestions returns the suggestions of a and those of b that are
+// not in a, at most maxSuggestions.
+func mergeSuggestions(a, b []string) []string {
+	for _, s := range b {
+		if len(a) == maxSuggestions {
+			break
+		}
+		if !containsString(a, s) {
+			a = append(a, s)
+		}
+	}
+	return a
+}
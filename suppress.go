@@ -0,0 +1,167 @@
+package tmplcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// directivePrefix starts a comment that suppresses findings:
+//
+//	{{/* tmplcheck:ignore [rule] [reason] */}}
+//
+// in templates, which applies to the next action, or
+//
+//	//tmplcheck:ignore [rule] [reason]
+//
+// in go source, at the end of the line of an Execute call or on a line
+// of its own before it. Without a rule, all findings are suppressed. A
+// first word that looks like a rule but is not one, such as TC0001,
+// suppresses nothing, so that a misspelled rule does not hide every
+// finding, and the directive is reported as unused.
+const directivePrefix = "tmplcheck:ignore"
+
+// Suppression is a tmplcheck:ignore directive.
+type Suppression struct {
+	Path string // relative path of the template, or path of the go source file
+	Line int
+	Col  int
+
+	Rule   string // ID of the rule of the suppressed findings; empty for all rules, or unknown as written
+	Reason string // optional
+}
+
+// suppresses reports whether s suppresses findings of the rule. A nil
+// suppression suppresses nothing.
+func (s *Suppression) suppresses(rule string) bool {
+	return s != nil && (s.Rule == "" || s.Rule == rule)
+}
+
+// UnusedSuppression is a tmplcheck:ignore directive that suppressed no
+// finding.
+type UnusedSuppression struct {
+	Suppression Suppression
+
+	// Usage is the usage the directive applies to, for directives in go
+	// source. For directives that apply to no Execute call, only its
+	// Path, Filename, Pos, Line and Col are set, to those of the
+	// directive.
+	Usage *Usage
+
+	Severity Severity
+}
+
+func (e UnusedSuppression) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		Path     string   `json:"file"`
+		Line     int      `json:"line"`
+		Col      int      `json:"col"`
+		Suppress string   `json:"suppresses,omitempty"` // rule ID
+		Reason   string   `json:"reason,omitempty"`
+		Rule     string   `json:"rule"`
+		Severity Severity `json:"severity"`
+	}{
+		e.Suppression.Path,
+		e.Suppression.Line,
+		e.Suppression.Col,
+		e.Suppression.Rule,
+		e.Suppression.Reason,
+		RuleUnusedSuppression,
+		e.Severity,
+	}
+
+	return json.Marshal(aux)
+}
+
+func (e UnusedSuppression) String() string {
+	return fmt.Sprintf(
+		"%s:%d:%d: %s%s [%s]",
+		e.Suppression.Path, e.Suppression.Line, e.Suppression.Col, e.Severity.label(), e.message(), RuleUnusedSuppression,
+	)
+}
+
+func (e UnusedSuppression) message() string {
+	if r := e.Suppression.Rule; r != "" && LookupRule(r) == nil {
+		return fmt.Sprintf("unknown rule %q in %s directive", r, directivePrefix)
+	}
+	return "unused " + directivePrefix + " directive"
+}
+
+// ruleLike matches words that look like rule IDs or names, such as
+// TC0001 or missing-keys.
+var ruleLike = regexp.MustCompile(`^(?i:tc\d+)$|^[a-z]+(-[a-z]+)+$`)
+
+// parseDirective returns the suppression for the text of a comment,
+// without the comment markers, or nil if it is not a directive. The
+// rule is the first word after the prefix if it is the name of a rule,
+// or kept as it is if it looks like one; the rest of the text is the
+// reason. Rules are given by ID or name and stored by ID.
+func parseDirective(text string) *Suppression {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, directivePrefix) {
+		return nil
+	}
+	rest := text[len(directivePrefix):]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return nil // such as tmplcheck:ignored
+	}
+	rest = strings.TrimSpace(rest)
+
+	s := &Suppression{Reason: rest}
+	if f := strings.Fields(rest); len(f) != 0 {
+		if r := LookupRule(f[0]); r != nil {
+			s.Rule = r.ID
+			s.Reason = strings.TrimSpace(rest[len(f[0]):])
+		} else if ruleLike.MatchString(f[0]) {
+			s.Rule = f[0] // suppresses nothing
+			s.Reason = strings.TrimSpace(rest[len(f[0]):])
+		}
+	}
+	return s
+}
+
+// goDirective is a tmplcheck:ignore directive in go source.
+type goDirective struct {
+	s       *Suppression
+	pos     token.Pos
+	ownLine bool // whether the comment is alone on its line
+}
+
+// goDirectives returns the tmplcheck:ignore directives in the file by
+// line.
+func goDirectives(g *goFile, f *ast.File) map[int]goDirective {
+	ret := make(map[int]goDirective)
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if !strings.HasPrefix(c.Text, "//") {
+				continue
+			}
+			s := parseDirective(c.Text[2:])
+			if s == nil {
+				continue
+			}
+			s.Path = g.path
+			s.Line, s.Col = g.position(c.Pos())
+			ret[s.Line] = goDirective{s: s, pos: c.Pos(), ownLine: g.startsLine(s.Line, s.Col)}
+		}
+	}
+	return ret
+}
+
+// unattached returns the unused suppression for the directive, which
+// applies to no Execute call in the file.
+func (d goDirective) unattached(g *goFile) UnusedSuppression {
+	return UnusedSuppression{
+		Suppression: *d.s,
+		Usage: &Usage{
+			Path:     g.path,
+			Filename: g.file.Name(),
+			Pos:      d.pos,
+			Line:     d.s.Line,
+			Col:      d.s.Col,
+		},
+	}
+}
@@ -1,95 +1,141 @@
-package main
+package tmplcheck
 
 import (
-	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	htemplate "html/template"
 	tparse "text/template/parse"
-)
-
-/*
-NOTES
-=====
-
-From the text/template package comment: "Actions"--data evaluations or control
-structures--are delimited by "{{" and "}}".
-
-From the documentation for text/template/parse:
-
-Pipeline nodes (type PipeNode) contains []*VariableNode and []*CommandNode, which are
-of interest to us to determine fields in use in a template.
-Also see: type FieldNode and type ChainNode.
 
-The following type contain a PipeNode directly:
-
-  * ActionNode
-  * BranchNode
-  * TemplateNode
-
-Other types contain or embed one of the above 3 types, thus indirectly containing a PipeNode:
-
-  * IfNode
-  * RangeNode
-  * WithNode
+	"github.com/go-web-framework/tmplcheck/visit"
+)
 
-ListNode is used for traversals. In addition to the Tree root, it is present in BranchNode.
-*/
+// See the NOTES in package visit for the structure of parse trees.
 
 // TemplateIdent is identifiers and their position in the file.
 type TemplateIdent struct {
 	Path string     // Relative path of identifier's file
-	Pos  tparse.Pos // Byte position in file
+	Pos  tparse.Pos // Byte offset of the start of the identifiers in file
+	End  tparse.Pos // Byte offset of the end of the identifiers in file
 	Line int        // Line number in file
-	Col  int        // Column in file
+	Col  int        // Column in file, in characters
+
+	// EndLine and EndCol are the position of the end of the identifiers,
+	// such as after .User.Name, so EndCol is the column after the last
+	// character.
+	EndLine int
+	EndCol  int
+
+	// LineText is the text of the line in the file, without the line
+	// ending.
+	LineText string
 
 	// Idents is the identifiers at the location. It is a
 	// list to account for cases such as FieldNode in which
 	// identitifers are chained.
 	Idents []string
-}
 
-func lineCol(byteOffset int, lines [][]byte) (line int, col int) {
-	prevTotal := -1
-	total := 0
-	line = 1
+	// Suppression is the tmplcheck:ignore directive that applies to
+	// the identifiers, if any.
+	Suppression *Suppression
+}
 
-	for _, l := range lines {
-		prevTotal = total
-		total += len(l) + 1 // + 1 for \n
-		if total >= byteOffset {
-			break
-		}
-		line++
-	}
+// parsedTemplate is what is found in a template by parseTemplate.
+type parsedTemplate struct {
+	idents       []TemplateIdent
+	suppressions []*Suppression
+	funcArgs     []FuncArgsError
+
+	// names is the field names used anywhere in the template. If opaque,
+	// the template uses the data as a whole, for instance to pass it to
+	// another template, so it may use any field.
+	names  map[string]bool
+	opaque bool
+
+	tree  *tparse.Tree
+	index *lineIndex
+	sups  map[tparse.Pos]*Suppression // suppression for fields, variables and chains
+}
 
-	if prevTotal != -1 {
-		col = byteOffset - prevTotal
-	} else {
-		col = byteOffset
+// ident returns the TemplateIdent for the identifiers of the node, which
+// is a field, variable, chain or identifier.
+func (pt parsedTemplate) ident(node tparse.Node, idents []string) TemplateIdent {
+	start, end := nodeSpan(pt.index.src, node)
+	l, c := pt.index.position(start)
+	el, ec := pt.index.position(end)
+	return TemplateIdent{
+		Path:        pt.tree.Name,
+		Pos:         tparse.Pos(start),
+		End:         tparse.Pos(end),
+		Line:        l,
+		Col:         c,
+		EndLine:     el,
+		EndCol:      ec,
+		LineText:    pt.index.text(l),
+		Idents:      idents,
+		Suppression: pt.sups[node.Position()],
 	}
-
-	return
 }
 
-func parseTemplate(b []byte, relpath string) ([]TemplateIdent, error) {
-	const someName = ""
-	t, err := htemplate.New(someName).Delims(LeftDelim, RightDelim).Parse(string(b))
+// parseTemplate returns the identifiers used in the template and its
+// tmplcheck:ignore directives. The returned ParseError is non-nil if
+// the template could not be parsed or analyzed.
+func parseTemplate(b []byte, relpath string, cfg *Config) (parsedTemplate, *ParseError) {
+	// The template is named after its path so that parse errors, which
+	// are prefixed with the name, can be mapped back to the file.
+	// See newParseError.
+	index := newLineIndex(b)
+	_, err := htemplate.New(relpath).Delims(cfg.LeftDelim, cfg.RightDelim).Parse(string(b))
 	if err != nil {
-		return nil, err
+		pe := newParseError(relpath, err, index)
+		return parsedTemplate{}, &pe
+	}
+
+	// html/template does not keep comments, which hold directives, so the
+	// template is parsed again. Functions were checked by the first parse.
+	tree := tparse.New(relpath)
+	tree.Mode = tparse.ParseComments | tparse.SkipFuncCheck
+	if _, err := tree.Parse(string(b), cfg.LeftDelim, cfg.RightDelim, make(map[string]*tparse.Tree)); err != nil {
+		pe := newParseError(relpath, err, index)
+		return parsedTemplate{}, &pe
 	}
 
-	var ret []TemplateIdent
+	ret := parsedTemplate{
+		names: make(map[string]bool),
+		tree:  tree,
+		index: index,
+		sups:  make(map[tparse.Pos]*Suppression),
+	}
 
-	// TODO(nishanths): Does Windows line-ending need
-	// different handling?
-	// TODO(nishanths): unimportant: Perform incremental search since
-	// we are only moving forward thru the file.
-	lines := bytes.Split(b, []byte("\n"))
+	// A directive applies to the next node in the same list, other than
+	// text. See suppressionAt.
+	var pending *Suppression
+	targets := make(map[tparse.Node]*Suppression)
+
+	err = visit.Walk(tree, nil, visit.Funcs{EnterFunc: func(node tparse.Node, sc *visit.Scope) error {
+		if _, ok := sc.Parent().(*tparse.ListNode); ok {
+			switch n := node.(type) {
+			case *tparse.TextNode:
+			case *tparse.CommentNode:
+				if d := parseDirective(strings.TrimSuffix(strings.TrimPrefix(n.Text, "/*"), "*/")); d != nil {
+					d.Path = relpath
+					d.Line, d.Col = index.position(int(n.Pos))
+					ret.suppressions = append(ret.suppressions, d)
+					pending = d
+				}
+			default:
+				if pending != nil {
+					targets[node] = pending
+					pending = nil
+				}
+			}
+		}
 
-	err = Walk(t.Tree.Root, func(node tparse.Node) error {
 		switch n := node.(type) {
 		case *tparse.IdentifierNode:
 			// XXX(nishanths): According to tparse doc, NodeIdentifier is
@@ -101,162 +147,190 @@ func parseTemplate(b []byte, relpath string) ([]TemplateIdent, error) {
 			if n.NodeType == tparse.NodeIdentifier {
 				break
 			}
-			l, c := lineCol(int(n.Pos), lines)
-			ret = append(ret, TemplateIdent{
-				Path:   relpath,
-				Pos:    n.Pos,
-				Line:   l,
-				Col:    c,
-				Idents: []string{n.Ident},
-			})
+			ti := ret.ident(n, []string{n.Ident})
+			ti.Suppression = suppressionAt(sc, targets)
+			ret.idents = append(ret.idents, ti)
 		case *tparse.FieldNode:
-			l, c := lineCol(int(n.Pos), lines)
-			ret = append(ret, TemplateIdent{
-				Path:   relpath,
-				Pos:    n.Pos,
-				Line:   l,
-				Col:    c,
-				Idents: n.Ident,
-			})
+			ret.sups[n.Pos] = suppressionAt(sc, targets)
+			addNames(ret.names, n.Ident)
+			ret.idents = append(ret.idents, ret.ident(n, n.Ident))
+		case *tparse.ChainNode:
+			ret.sups[n.Pos] = suppressionAt(sc, targets)
+			addNames(ret.names, n.Field)
+		case *tparse.VariableNode:
+			ret.sups[n.Pos] = suppressionAt(sc, targets)
+			addNames(ret.names, n.Ident[1:])
+			if len(n.Ident) == 1 && n.Ident[0] == "$" {
+				ret.opaque = true
+			}
+		case *tparse.DotNode:
+			if isRootDot(sc) {
+				ret.opaque = true // such as {{template "name" .}}
+			}
+		case *tparse.CommandNode:
+			pipe, _ := sc.Parent().(*tparse.PipeNode)
+			if e := checkFuncArgs(n, pipe); e != nil {
+				e.TemplateIdent = ret.ident(n.Args[0], e.TemplateIdent.Idents)
+				e.TemplateIdent.Suppression = suppressionAt(sc, targets)
+				ret.funcArgs = append(ret.funcArgs, *e)
+			}
 		default:
 			// This branch is purely for documenting the source code:
 			// we do not care about types besides the above.
 		}
 		return nil
-	})
+	}, LeaveFunc: func(node tparse.Node, _ *visit.Scope) error {
+		if _, ok := node.(*tparse.ListNode); ok {
+			pending = nil // directives do not apply past the end of a list
+		}
+		return nil
+	}})
+	if err != nil {
+		pe := ParseError{Path: relpath, Msg: err.Error()}
+		if ne, ok := err.(*visit.UnknownNodeError); ok {
+			pe.Line, pe.Col = index.position(int(ne.Node.Position()))
+		}
+		return parsedTemplate{}, &pe
+	}
+
+	return ret, nil
+}
+
+// isRootDot reports whether dot at the scope is the data passed to the
+// template, and not an element of a range or the value of a with.
+func isRootDot(sc *visit.Scope) bool {
+	for i, p := range sc.Parents[:len(sc.Parents)-1] {
+		var b *tparse.BranchNode
+		switch p := p.(type) {
+		case *tparse.RangeNode:
+			b = &p.BranchNode
+		case *tparse.WithNode:
+			b = &p.BranchNode
+		default:
+			continue
+		}
+		if sc.Parents[i+1] == b.List {
+			return false
+		}
+	}
+	return true
+}
 
-	return ret, err
+func addNames(m map[string]bool, names []string) {
+	for _, n := range names {
+		m[n] = true
+	}
 }
 
-func parseTemplates(root string) (map[string][]TemplateIdent, error) {
-	ret := make(map[string][]TemplateIdent)
+// suppressionAt returns the directive that applies to the node with
+// the scope, or nil. A directive before an action applies to the whole
+// action; before an if, range, with or template, it applies to the
+// pipeline only, and not to the nodes in the branches.
+func suppressionAt(sc *visit.Scope, targets map[tparse.Node]*Suppression) *Suppression {
+	for i, p := range sc.Parents {
+		d := targets[p]
+		if d == nil {
+			continue
+		}
+		var pipe *tparse.PipeNode
+		switch p := p.(type) {
+		case *tparse.ActionNode:
+			return d
+		case *tparse.TemplateNode:
+			return d
+		case *tparse.IfNode:
+			pipe = p.Pipe
+		case *tparse.RangeNode:
+			pipe = p.Pipe
+		case *tparse.WithNode:
+			pipe = p.Pipe
+		}
+		if pipe != nil && i+1 < len(sc.Parents) && sc.Parents[i+1] == pipe {
+			return d
+		}
+	}
+	return nil
+}
+
+// parseErrorRx matches the position prefix of text/template/parse errors
+// after the template name, which is either "line:" or "line:col:".
+var parseErrorRx = regexp.MustCompile(`^:(\d+):(?:(\d+):)? (.*)$`)
+
+// newParseError converts an error returned from parsing the template
+// at relpath into a ParseError. The line and column are taken from the
+// error message when available; the column in messages is a 0-based
+// byte offset in the line, which is converted with the index.
+func newParseError(relpath string, err error, index *lineIndex) ParseError {
+	pe := ParseError{Path: relpath, Msg: err.Error()}
+
+	rest := strings.TrimPrefix(err.Error(), "template: "+relpath)
+	m := parseErrorRx.FindStringSubmatch(rest)
+	if m == nil {
+		return pe
+	}
+	pe.Line, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		col, _ := strconv.Atoi(m[2])
+		_, pe.Col = index.position(index.offset(pe.Line, col))
+	}
+	pe.Msg = m[3]
+	return pe
+}
+
+// parseTemplates parses the templates in the cfg.Templates directory. Templates
+// that fail to parse are reported in the returned ParseError map and
+// do not stop the other templates from being parsed. The returned error
+// is non-nil only if the templates directory could not be read.
+func parseTemplates(ctx context.Context, cfg *Config) (map[string]parsedTemplate, map[string]ParseError, error) {
+	ret := make(map[string]parsedTemplate)
+	parseErrs := make(map[string]ParseError)
+	root := cfg.Templates
 
 	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if info.IsDir() {
 			return nil
 		}
 
-		b, err := ioutil.ReadFile(p)
-		if err != nil {
-			return err
-		}
 		relp, err := filepath.Rel(root, p)
 		if err != nil {
 			return err
 		}
-
-		idents, err := parseTemplate(b, relp)
+		if !cfg.includes(relp) {
+			return nil
+		}
+		pt, pe, err := parseTemplateFile(relp, cfg)
 		if err != nil {
 			return err
 		}
-		ret[relp] = idents
+		if pe != nil {
+			parseErrs[relp] = *pe
+			return nil
+		}
+		ret[relp] = pt
 		return nil
 	})
 
-	return ret, err
+	return ret, parseErrs, err
 }
 
-type WalkFunc func(node tparse.Node) error
-
-func walk(root tparse.Node, fx WalkFunc, incomingErr error) error {
-	if incomingErr != nil {
-		return incomingErr
-	}
-
-	var err error
-
-	// XXX(nishanths): panic is okay for now because this is a main
-	// program. We expect that the paths with panic calls will never be reached,
-	// but would be nice to know of cases when it happens.
-
-	// NOTE(nishanths): The err = funccall(.., err) style with the incoming
-	// error check means that we do not have to check err != nil each
-	// time (helps readability). However, this has the added overhead of the
-	// function calls even after a non-nil error has been detected.
-
-	switch n := root.(type) {
-	case nil:
-		// Nothing to do.
-		// TODO(nishanths): Does this case ever happen?
-	case *tparse.ListNode:
-		for _, n := range n.Nodes {
-			err = walk(n, fx, err)
-		}
-	case *tparse.ActionNode:
-		err = fx(n)
-		err = walk(n.Pipe, fx, err)
-	case *tparse.BoolNode:
-		err = fx(n)
-	case *tparse.BranchNode:
-		// This is not a concrete node type that will be encountered.
-		// See IfNode, WithNode, and RangeNode instead.
-		panic("tmplcheck: expected BranchNode to not be a concrete node type")
-	case *tparse.ChainNode:
-		err = fx(n)
-		err = walk(n.Node, fx, err)
-	case *tparse.CommandNode:
-		err = fx(n)
-		for _, a := range n.Args {
-			err = walk(a, fx, err)
-		}
-	case *tparse.DotNode:
-		err = fx(n)
-	case *tparse.FieldNode:
-		err = fx(n)
-	case *tparse.IdentifierNode:
-		err = fx(n)
-	case *tparse.IfNode:
-		err = fx(n)
-		err = walk(n.Pipe, fx, err)
-		err = walk(n.List, fx, err)
-		if n.ElseList != nil {
-			err = walk(n.ElseList, fx, err)
-		}
-	case *tparse.NilNode:
-		err = fx(n)
-	case *tparse.NumberNode:
-		err = fx(n)
-	case *tparse.PipeNode:
-		err = fx(n)
-		for _, v := range n.Decl {
-			err = walk(v, fx, err)
-		}
-		for _, v := range n.Cmds {
-			err = walk(v, fx, err)
-		}
-	case *tparse.RangeNode:
-		err = fx(n)
-		err = walk(n.Pipe, fx, err)
-		err = walk(n.List, fx, err)
-		if n.ElseList != nil {
-			err = walk(n.ElseList, fx, err)
-		}
-	case *tparse.StringNode:
-		err = fx(n)
-	case *tparse.TemplateNode:
-		err = fx(n)
-		err = walk(n.Pipe, fx, err)
-	case *tparse.TextNode:
-		err = fx(n)
-	case *tparse.VariableNode:
-		err = fx(n)
-	case *tparse.WithNode:
-		err = fx(n)
-		err = walk(n.Pipe, fx, err)
-		err = walk(n.List, fx, err)
-		if n.ElseList != nil {
-			err = walk(n.ElseList, fx, err)
+// parseTemplateFile parses the template at relpath in cfg.Templates,
+// or its contents in cfg.Overlay. The returned error is non-nil only
+// if the file could not be read.
+func parseTemplateFile(relpath string, cfg *Config) (parsedTemplate, *ParseError, error) {
+	b, ok := cfg.Overlay[relpath]
+	if !ok {
+		var err error
+		b, err = ioutil.ReadFile(filepath.Join(cfg.Templates, relpath))
+		if err != nil {
+			return parsedTemplate{}, nil, err
 		}
-	default:
-		panic("tmplcheck: encountered unknown node type")
 	}
-
-	return err
-}
-
-func Walk(node tparse.Node, fx WalkFunc) error {
-	return walk(node, fx, nil)
+	pt, pe := parseTemplate(b, relpath, cfg)
+	return pt, pe, nil
 }
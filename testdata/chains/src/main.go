@@ -0,0 +1,29 @@
+// Package main executes a template that uses the same field name in
+// different chains, with a struct and with a map.
+package main
+
+import (
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+type user struct {
+	Name string
+}
+
+type author struct {
+	Name string
+}
+
+type page struct {
+	User   *user
+	Author *author
+}
+
+func main() {
+	set := &templates.Set{}
+
+	set.Execute("page.html", os.Stdout, page{User: &user{}})
+	set.Execute("page.html", os.Stdout, map[string]interface{}{"User": nil, "Author": nil})
+}
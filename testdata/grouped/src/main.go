@@ -0,0 +1,26 @@
+// Package main executes a template from several calls that do not pass
+// all of its keys.
+package main
+
+import (
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+type page struct {
+	Title string
+}
+
+type userPage struct {
+	Title string
+	Name  string
+}
+
+func main() {
+	set := &templates.Set{}
+
+	set.Execute("page.html", os.Stdout, page{Title: "a"})
+	set.Execute("page.html", os.Stdout, page{Title: "b"})
+	set.Execute("page.html", os.Stdout, userPage{Title: "c", Name: "c"})
+}
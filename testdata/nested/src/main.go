@@ -0,0 +1,38 @@
+// Package main executes a template that uses fields, methods, map values
+// and interface methods of the keys it passes.
+package main
+
+import (
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+type author struct {
+	Name string
+}
+
+// Initials is a method with a result, which templates can evaluate.
+func (a *author) Initials() string {
+	return a.Name[:1]
+}
+
+// Print is a method with no result, which templates cannot evaluate.
+func (a *author) Print() {}
+
+type named interface {
+	Name() string
+}
+
+type page struct {
+	Author *author
+	Meta   map[string]string
+	Named  named
+	Any    interface{}
+}
+
+func main() {
+	set := &templates.Set{}
+
+	set.Execute("page.html", os.Stdout, page{Author: &author{}, Meta: map[string]string{}, Named: nil, Any: nil})
+}
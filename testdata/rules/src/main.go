@@ -0,0 +1,27 @@
+// Package main executes templates with findings of each rule.
+package main
+
+import (
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+type user struct {
+	Name string
+}
+
+type page struct {
+	Title string
+	Items []string
+	Extra string
+	user  user
+}
+
+func main() {
+	set := &templates.Set{}
+
+	set.Execute("page.html", os.Stdout, page{Title: "t", Items: nil, Extra: "x", user: user{}})
+	set.Execute("pgae.html", os.Stdout, page{Title: "t"})
+	set.Execute("ignored.html", os.Stdout, nil)
+}
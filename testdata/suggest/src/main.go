@@ -0,0 +1,30 @@
+// Package main executes a template with misspelled keys and fields.
+package main
+
+import (
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+type author struct {
+	Name string
+}
+
+type page struct {
+	Title    string
+	UserName string
+	Author   *author
+}
+
+func (p page) Summary() string {
+	return p.Title
+}
+
+func main() {
+	set := &templates.Set{}
+
+	set.Execute("page.html", os.Stdout, page{Title: "t", UserName: "u", Author: &author{}})
+	set.Execute("pages.html", os.Stdout, page{Title: "t"})
+	set.Execute("summary.html", os.Stdout, page{Title: "t"})
+}
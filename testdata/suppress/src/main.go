@@ -0,0 +1,26 @@
+// Package main executes templates with tmplcheck:ignore directives.
+package main
+
+import (
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+func render(set *templates.Set, name string) {
+	set.Execute(name, os.Stdout, nil) //tmplcheck:ignore unverifiable name is checked by the caller
+}
+
+func main() {
+	set := &templates.Set{}
+
+	set.Execute("page.html", os.Stdout, map[string]interface{}{"Title": "t"})
+
+	//tmplcheck:ignore missing-key Body is set by middleware
+	set.Execute("body.html", os.Stdout, nil)
+
+	set.Execute("body.html", os.Stdout, map[string]interface{}{"Body": "b"}) //tmplcheck:ignore
+	set.Execute("body.html", os.Stdout, nil)
+
+	render(set, "page.html") //tmplcheck:ignore unknown-template not an Execute call
+}
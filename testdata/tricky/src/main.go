@@ -0,0 +1,55 @@
+// Package main contains unusual Execute calls that tmplcheck must
+// handle without crashing.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+type page struct {
+	Title string
+	Count int
+}
+
+const (
+	prefix               = "tricky/"
+	keyedName, breakName = "keyed.html", "break.html"
+	computedName         = prefix + "keyed.html"
+)
+
+var shared = page{Title: "shared"}
+
+func key() string { return "Title" }
+
+func main() {
+	set := &templates.Set{}
+
+	// Positional struct literal.
+	set.Execute(keyedName, os.Stdout, page{"t", 1})
+
+	// Constant name that is not a basic literal.
+	set.Execute(computedName, os.Stdout, nil)
+
+	// Second name in a multi-name const spec.
+	set.Execute(breakName, os.Stdout, map[string]interface{}{"Items": nil})
+
+	// Map key that is not a literal.
+	set.Execute("keyed.html", os.Stdout, map[string]interface{}{key(): "x"})
+
+	// Slice literal.
+	set.Execute("keyed.html", ioutil.Discard, []string{"a"})
+
+	// Raw string name; second variable of a multiple assignment.
+	a, b := page{Title: "a"}, map[string]interface{}{"Title": "b", "Count": 2}
+	_ = a
+	set.Execute(`keyed.html`, os.Stdout, b)
+
+	// Package-level variable declared in the same file.
+	set.Execute("keyed.html", os.Stdout, shared)
+
+	set.Execute("include.html", os.Stdout, page{Title: "include"})
+	set.Execute("chain.html", os.Stdout, map[string]interface{}{"User": nil, "A": nil})
+}
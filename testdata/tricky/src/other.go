@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/go-web-framework/templates"
+)
+
+func other(set *templates.Set) {
+	// Package-level variable declared in another file. Its ident is
+	// not resolved by the parser.
+	set.Execute("keyed.html", os.Stdout, shared)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-web-framework/templates"
+)
+
+type page struct {
+	Title string
+}
+
+func buildData() page {
+	return page{Title: "hello"}
+}
+
+func render(set *templates.Set, name string) error {
+	return set.Execute(name, os.Stdout, nil)
+}
+
+func main() {
+	set := &templates.Set{}
+	err := set.Parse(filepath.Join("..", "templates"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = set.Execute("root.html", os.Stdout, buildData())
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = render(set, "root.html")
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -1,17 +1,19 @@
-package main
+package tmplcheck
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"flag"
 	"fmt"
 	"go/ast"
+	"go/build"
+	"go/parser"
 	"go/token"
-	"io"
-	"os"
-	"path/filepath"
-	"strings"
+	"go/types"
+	"path"
+	"sort"
+	"strconv"
 
+	"golang.org/x/tools/go/buildutil"
 	"golang.org/x/tools/go/loader"
 )
 
@@ -20,82 +22,128 @@ import (
 // * Nested calls, better static analysis, check reflection code for panics
 // * Default args in Set should be considered in analysis
 // * Support for html/template and text/template
-// * Colorize plain text output
 
-var (
-	TemplatesPath string
-	PackagePath   string
-	LeftDelim     string
-	RightDelim    string
-	OutputFormat  string
-)
-
-func main() {
-	flag.StringVar(&TemplatesPath, "t", "", "path to templates directory")
-	flag.StringVar(&PackagePath, "p", "", "package import path")
-	flag.StringVar(&LeftDelim, "ldelim", "{{", "left delimiter in templates")
-	flag.StringVar(&RightDelim, "rdelim", "}}", "right delimiter in templates")
-	flag.StringVar(&OutputFormat, "format", "plain", "output format (plain,json)")
-	flag.Parse()
-
-	mainImpl()
+// Config is the configuration for Check and CheckUsages.
+type Config struct {
+	Package   string // import path of the go package; required by Check unless Packages is set
+	Templates string // path to the templates directory; required
+
+	// Packages are more go packages that execute the templates, as
+	// import paths or patterns such as "example.com/app/...".
+	Packages []string
+
+	LeftDelim  string // left delimiter in templates; defaults to "{{"
+	RightDelim string // right delimiter in templates; defaults to "}}"
+
+	// Include and Ignore are glob patterns of template files, relative
+	// to Templates. If Include is set, only matching files are checked.
+	// Files matching Ignore are not checked. See matchGlob for the syntax.
+	Include []string
+	Ignore  []string
+
+	// Severity overrides DefaultSeverity for some rules, given by ID or
+	// name, and Overrides override both for the templates in some
+	// directories. Findings with SeverityOff are not reported.
+	Severity  map[string]Severity
+	Overrides []Override
+
+	// Overlay maps template paths, relative to Templates, to contents
+	// that are used instead of the contents of the files on disk, such
+	// as unsaved changes in an editor. The files must exist on disk.
+	Overlay map[string][]byte
 }
 
-// checkArgs checks that required arguments are provided
-// and fills in defaults for others. Defaults are filled
-// in here also besides in flags to help with testing.
-func checkArgs() {
-	if TemplatesPath == "" {
-		exitErr("-t is required")
+// withDefaults checks that required fields are provided and returns
+// a copy of the config with defaults filled in for the others.
+func (c *Config) withDefaults() (*Config, error) {
+	if c.Templates == "" {
+		return nil, errors.New("tmplcheck: templates path is required")
 	}
+	for _, pat := range append(append([]string(nil), c.Include...), c.Ignore...) {
+		if _, err := path.Match(pat, ""); err != nil {
+			return nil, fmt.Errorf("tmplcheck: bad pattern %q: %v", pat, err)
+		}
+	}
+
+	ret := *c
 
-	if PackagePath == "" {
-		exitErr("-p is required")
+	var err error
+	if ret.Severity, err = ruleSeverities(c.Severity); err != nil {
+		return nil, err
+	}
+	ret.Overrides = nil
+	for _, o := range c.Overrides {
+		if o.Severity, err = ruleSeverities(o.Severity); err != nil {
+			return nil, err
+		}
+		ret.Overrides = append(ret.Overrides, o)
 	}
 
-	if LeftDelim == "" {
-		LeftDelim = "{{"
+	if ret.LeftDelim == "" {
+		ret.LeftDelim = "{{"
 	}
 
-	if RightDelim == "" {
-		RightDelim = "}}"
+	if ret.RightDelim == "" {
+		ret.RightDelim = "}}"
 	}
 
-	if OutputFormat == "" {
-		OutputFormat = "plain"
+	return &ret, nil
+}
+
+// Check checks the go source files in the package against the template
+// files. Problems found in the source and templates are reported in the
+// Result; the returned error is non-nil only if the check could not be
+// performed, for instance if the package fails to load.
+func Check(ctx context.Context, cfg *Config) (*Result, error) {
+	if cfg.Package == "" && len(cfg.Packages) == 0 {
+		return nil, errors.New("tmplcheck: package import path is required")
 	}
-	if OutputFormat != "json" && OutputFormat != "plain" {
-		exitErr(`unsupported output format: "` + OutputFormat + `"`)
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
 	}
-}
 
-func exitErr(v interface{}) {
-	fmt.Fprintln(os.Stderr, v)
-	os.Exit(1)
+	usages, unverifiable, unattached, templates, parseErrs, err := goParseAll(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return doCheck(cfg, usages, unverifiable, unattached, templates, parseErrs, nil), nil
 }
 
-func output(w io.Writer, results []checkResult) {
-	switch OutputFormat {
-	case "plain":
-		for i, r := range results {
-			fmt.Fprintln(w, &r)
-			if i != len(results)-1 {
-				fmt.Fprintln(w)
-			}
-		}
-	case "json":
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
-		if err := enc.Encode(results); err != nil {
-			exitErr(err)
-		}
+// CheckUsages is like Check, but checks usages already found in go
+// source, typically by FindUsages, instead of loading cfg.Package.
+// unattached are the directives in go source that apply to no usage,
+// which are reported as unused.
+func CheckUsages(ctx context.Context, cfg *Config, usages map[string][]Usage, unverifiable []UnverifiableUsage, unattached []UnusedSuppression) (*Result, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
 	}
+
+	templates, parseErrs, err := parseTemplates(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return doCheck(cfg, usages, unverifiable, unattached, templates, parseErrs, nil), nil
 }
 
-func mainImpl() {
-	checkArgs()
-	results := DoAll()
-	output(os.Stdout, results)
+// ImportPaths returns the import paths of the go packages to check:
+// Package and the packages matching Packages, sorted.
+func (c *Config) ImportPaths() []string {
+	var patterns []string
+	if c.Package != "" {
+		patterns = append(patterns, c.Package)
+	}
+	patterns = append(patterns, c.Packages...)
+
+	var ret []string
+	for p := range buildutil.ExpandPatterns(&build.Default, patterns) {
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+	return ret
 }
 
 func containsString(slice []string, target string) bool {
@@ -107,40 +155,96 @@ func containsString(slice []string, target string) bool {
 	return false
 }
 
-// identValue returns the first value for the ident.
+// nameIndex returns the index of the ident in names, or -1.
+func nameIndex(names []*ast.Ident, a *ast.Ident) int {
+	for i, n := range names {
+		if n.Name == a.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+// identValue returns the value of the string constant or variable
+// declared for the ident.
 func identValue(a *ast.Ident) (string, error) {
 	if a.Obj == nil || a.Obj.Decl == nil {
 		return "", errors.New("failed to determine Decl")
 	}
 	vspec, ok := a.Obj.Decl.(*ast.ValueSpec)
-	if !ok || len(vspec.Values) == 0 {
+	if !ok {
+		return "", errors.New("unknown value")
+	}
+	i := nameIndex(vspec.Names, a)
+	if i < 0 || i >= len(vspec.Values) {
+		return "", errors.New("unknown value")
+	}
+	lit, ok := vspec.Values[i].(*ast.BasicLit)
+	if !ok {
 		return "", errors.New("unknown value")
 	}
-	return vspec.Values[0].(*ast.BasicLit).Value, nil
+	return stringLitValue(lit)
+}
+
+// stringLitValue returns the unquoted value of a string literal.
+func stringLitValue(lit *ast.BasicLit) (string, error) {
+	if lit.Kind != token.STRING {
+		return "", errors.New("not a string literal")
+	}
+	return strconv.Unquote(lit.Value)
 }
 
-func compositeLitKeys(comp *ast.CompositeLit) []string {
+// compositeLitKeys returns the keys in a keyed composite literal.
+// Positional literals, such as Page{"title", 1}, return errPositionalArgs.
+func compositeLitKeys(comp *ast.CompositeLit) ([]string, error) {
 	var ret []string
 	for _, e := range comp.Elts {
-		k := e.(*ast.KeyValueExpr).Key
-		switch x := k.(type) {
-		// XXX: more possibilities here?
+		kv, ok := e.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, errPositionalArgs
+		}
+		switch x := kv.Key.(type) {
 		case *ast.BasicLit:
-			ret = append(ret, x.Value) // map
+			v, err := stringLitValue(x) // map
+			if err != nil {
+				return nil, errUnsupportedKey
+			}
+			ret = append(ret, v)
 		case *ast.Ident:
 			ret = append(ret, x.Name) // struct
+		default:
+			return nil, errUnsupportedKey
 		}
 	}
-	return ret
+	return ret, nil
 }
 
 func identToCompositeLit(id *ast.Ident) (*ast.CompositeLit, error) {
+	if id.Obj == nil || id.Obj.Decl == nil {
+		return nil, errors.New("identToCompositeLit: failed to determine Decl")
+	}
 	asn, ok := id.Obj.Decl.(*ast.AssignStmt)
-	if !ok || len(asn.Rhs) == 0 {
+	if !ok {
 		return nil, errors.New("identToCompositeLit: wrong type")
 	}
 
-	cl, ok := asn.Rhs[0].(*ast.CompositeLit)
+	var lhs []*ast.Ident
+	for _, e := range asn.Lhs {
+		l, _ := e.(*ast.Ident) // nil for non-ident, such as x.f = ...
+		lhs = append(lhs, l)
+	}
+	i := -1
+	for j, l := range lhs {
+		if l != nil && l.Name == id.Name {
+			i = j
+			break
+		}
+	}
+	if i < 0 || i >= len(asn.Rhs) {
+		return nil, errors.New("identToCompositeLit: wrong type")
+	}
+
+	cl, ok := asn.Rhs[i].(*ast.CompositeLit)
 	if !ok {
 		return nil, errors.New("identToCompositeLit: wrong type")
 	}
@@ -156,11 +260,20 @@ type call interface {
 	Func() []string
 
 	// Handler returns the template name and the keys used inside
-	// the template.
+	// the template. A non-nil error means the call cannot be verified;
+	// the error describes why. The name is returned alongside the error
+	// if it could be determined.
 	Handler(callexpr *ast.CallExpr) (name string, keys []string, err error)
 }
 
-var errUnsupportedArgs = errors.New("unsupported type for arguments")
+var (
+	errUnsupportedArgs    = errors.New("unsupported type for arguments")
+	errPositionalArgs     = errors.New("composite literal for arguments has unkeyed fields")
+	errUnsupportedKey     = errors.New("unsupported key in composite literal for arguments")
+	errDynamicName        = errors.New("template name is not a constant")
+	errUnsupportedPackage = errors.New("template package is not supported yet")
+	errMissingArgs        = errors.New("unexpected number of arguments")
+)
 
 var supportedTemplatePackages = []call{
 	&templatesSet{},
@@ -200,24 +313,38 @@ func (t *templatesSet) Handler(callexpr *ast.CallExpr) (string, []string, error)
 	var name string
 	var keys []string
 
+	if len(callexpr.Args) != 3 {
+		return "", nil, errMissingArgs
+	}
+
 	// Args[0] is the name of the template.
 
 	switch a := callexpr.Args[0].(type) {
 	case *ast.BasicLit:
-		name = a.Value
+		n, err := stringLitValue(a)
+		if err != nil {
+			return "", nil, errDynamicName
+		}
+		name = n
 	case *ast.Ident:
 		n, err := identValue(a)
 		if err != nil {
-			return "", nil, err
+			return "", nil, errDynamicName
 		}
 		name = n
+	default:
+		return "", nil, errDynamicName
 	}
 
 	// Args[2] is the arguments being passed.
 
 	switch x := callexpr.Args[2].(type) {
 	case *ast.CompositeLit:
-		keys = compositeLitKeys(x)
+		k, err := compositeLitKeys(x)
+		if err != nil {
+			return name, nil, err
+		}
+		keys = k
 	case *ast.Ident:
 		// nil or variable name
 		if x.Name == "nil" {
@@ -225,18 +352,18 @@ func (t *templatesSet) Handler(callexpr *ast.CallExpr) (string, []string, error)
 		}
 		c, err := identToCompositeLit(x)
 		if err != nil {
-			return "", nil, err
+			return name, nil, errUnsupportedArgs
 		}
-		keys = compositeLitKeys(c)
+		k, err := compositeLitKeys(c)
+		if err != nil {
+			return name, nil, err
+		}
+		keys = k
 	default:
-		return "", nil, errUnsupportedArgs
+		return name, nil, errUnsupportedArgs
 	}
 
-	return trimQuotes(name), keys, nil
-}
-
-func trimQuotes(s string) string {
-	return strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+	return name, keys, nil
 }
 
 type htmltemplateTemplate struct{}
@@ -248,7 +375,7 @@ func (t *htmltemplateTemplate) Type() []string {
 func (t *htmltemplateTemplate) Func() []string { return []string{"Execute"} }
 
 func (t *htmltemplateTemplate) Handler(callexpr *ast.CallExpr) (string, []string, error) {
-	return "", nil, errors.New("unsupported type")
+	return "", nil, errUnsupportedPackage
 }
 
 type texttemplateTemplate struct{}
@@ -260,7 +387,7 @@ func (t *texttemplateTemplate) Type() []string {
 func (t *texttemplateTemplate) Func() []string { return []string{"Execute"} }
 
 func (t *texttemplateTemplate) Handler(callexpr *ast.CallExpr) (string, []string, error) {
-	return "", nil, errors.New("unsupported type")
+	return "", nil, errUnsupportedPackage
 }
 
 func doesMatch(typ, funcName string) (call, bool) {
@@ -280,40 +407,103 @@ func doesMatch(typ, funcName string) (call, bool) {
 
 // Usage represents a call to execute a template with the keys.
 type Usage struct {
-	Path string    // path of go source file
-	Pos  token.Pos // byte position of the method call in the go source file.
-	Line int
-
-	// TODO: Col is not supported yet. But not important
-	// since method calls are generally long lines, so Col is not that great.
-	// Col  int
+	Path     string    // path of go source file, relative to its module; see modulePath
+	Filename string    // full path of go source file, as given to the parser
+	Pos      token.Pos // byte position of the method call in the go source file.
+	Line     int
+	Col      int // column of the call, in characters
 
 	Obj  string // object on which method is called
 	Call string // called method name
+	Expr string // call expression, such as set.Execute("root.html", w, data)
+
+	Template string     // name of template being executed
+	Keys     []string   // keys passed to template
+	Data     types.Type // type of the data passed to template; nil if unknown
 
-	Template string   // name of template being executed
-	Keys     []string // keys passed to template
+	// Suppression is the tmplcheck:ignore directive for the call, if any.
+	Suppression *Suppression
 }
 
-func parsePackage(path string) (map[string][]Usage, error) {
-	var conf loader.Config
+// handle calls the handler for the call expression. A panic in the
+// handler is returned as an error so that one unexpected AST does not
+// stop the analysis.
+func handle(tl call, callexpr *ast.CallExpr) (name string, keys []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			name, keys, err = "", nil, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	return tl.Handler(callexpr)
+}
 
-	_, err := conf.FromArgs([]string{path}, false)
-	if err != nil {
-		return nil, err
+// UnverifiableUsage is a call to execute a template that cannot be
+// statically analyzed, for instance because the data argument is the
+// result of a function call.
+type UnverifiableUsage struct {
+	Usage    Usage  // Keys is always empty; Template is empty if not determined
+	Reason   string // why the usage cannot be verified
+	Severity Severity
+}
+
+// parsePackages returns the usages in the packages, keyed by template
+// name. Calls that cannot be analyzed are returned separately as
+// unverifiable usages and do not stop the analysis of other calls.
+// Directives that apply to no call are returned as unused suppressions.
+func parsePackages(ctx context.Context, paths []string) (map[string][]Usage, []UnverifiableUsage, []UnusedSuppression, error) {
+	if len(paths) == 0 {
+		return nil, nil, nil, errors.New("tmplcheck: no packages to check")
+	}
+
+	conf := loader.Config{ParserMode: parser.ParseComments} // for tmplcheck:ignore directives
+	for _, path := range paths {
+		conf.Import(path)
 	}
 
 	prog, err := conf.Load()
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
 	}
 
-	ourpkg := prog.Package(path)
+	usages := make(map[string][]Usage)
+	var unverifiable []UnverifiableUsage
+	var unattached []UnusedSuppression
+	for _, path := range paths {
+		ourpkg := prog.Package(path)
+		if ourpkg == nil {
+			return nil, nil, nil, fmt.Errorf("package %q was not loaded", path)
+		}
 
+		u, uv, us := FindUsages(prog.Fset, ourpkg.Files, &ourpkg.Info)
+		for name, v := range u {
+			usages[name] = append(usages[name], v...)
+		}
+		unverifiable = append(unverifiable, uv...)
+		unattached = append(unattached, us...)
+	}
+	return usages, unverifiable, unattached, nil
+}
+
+// FindUsages returns the usages in the type-checked files, keyed by
+// template name. Calls that cannot be analyzed are returned separately
+// as unverifiable usages. A //tmplcheck:ignore directive at the end of
+// the line of a call, or alone on the line before it, is set as the
+// usage's Suppression; other directives are returned as unused
+// suppressions. The files must be parsed with comments for directives to
+// be found.
+func FindUsages(fset *token.FileSet, files []*ast.File, info *types.Info) (map[string][]Usage, []UnverifiableUsage, []UnusedSuppression) {
 	ret := make(map[string][]Usage)
-	var retErr error
+	var unverifiable []UnverifiableUsage
+	var unattached []UnusedSuppression
+
+	for _, f := range files {
+		g := newGoFile(fset, f)
+		directives := goDirectives(g, f)
+		attached := make(map[int]bool) // lines of directives that apply to a call
 
-	for _, f := range ourpkg.Files {
 		ast.Inspect(f, func(n ast.Node) bool {
 			switch x := n.(type) {
 			case *ast.CallExpr:
@@ -326,7 +516,12 @@ func parsePackage(path string) (map[string][]Usage, error) {
 					break
 				}
 
-				typ := ourpkg.TypeOf(id).String()
+				t := info.TypeOf(id)
+				if t == nil {
+					// Not an expression, such as a package name.
+					break
+				}
+				typ := t.String()
 				funcName := selexpr.Sel.Name
 
 				tl, ok := doesMatch(typ, funcName)
@@ -335,29 +530,60 @@ func parsePackage(path string) (map[string][]Usage, error) {
 					break
 				}
 
-				name, keys, err := tl.Handler(x)
-				if err != nil {
-					retErr = err
-					return false
+				name, keys, err := handle(tl, x)
+
+				// The data is the last argument of all supported calls.
+				var data types.Type
+				if len(x.Args) != 0 {
+					data = info.TypeOf(x.Args[len(x.Args)-1])
 				}
 
-				file := prog.Fset.File(x.Fun.Pos())
-				ret[name] = append(ret[name], Usage{
-					Path: filepath.Base(file.Name()),
-					Pos:  x.Fun.Pos(),
-					Line: file.Line(x.Fun.Pos()),
+				u := Usage{
+					Path:     g.path,
+					Filename: g.file.Name(),
+					Pos:      x.Fun.Pos(),
 
 					Obj:  id.Name,
 					Call: funcName,
+					Expr: types.ExprString(x),
 
 					Template: name,
 					Keys:     keys,
-				})
+					Data:     data,
+				}
+				u.Line, u.Col = g.position(x.Fun.Pos())
+				if d, ok := directives[u.Line]; ok {
+					u.Suppression = d.s
+					attached[u.Line] = true
+				} else if d, ok := directives[u.Line-1]; ok && d.ownLine {
+					u.Suppression = d.s
+					attached[u.Line-1] = true
+				}
+
+				if err != nil {
+					unverifiable = append(unverifiable, UnverifiableUsage{
+						Usage:  u,
+						Reason: err.Error(),
+					})
+					break
+				}
+				ret[name] = append(ret[name], u)
 			}
 
 			return true
 		})
+
+		lines := make([]int, 0, len(directives))
+		for line := range directives {
+			if !attached[line] {
+				lines = append(lines, line)
+			}
+		}
+		sort.Ints(lines)
+		for _, line := range lines {
+			unattached = append(unattached, directives[line].unattached(g))
+		}
 	}
 
-	return ret, retErr
+	return ret, unverifiable, unattached
 }
@@ -1,20 +1,38 @@
-package main
+package tmplcheck
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-// runTest is intended to be the testing equivalent of mainImpl.
-func runTest(ppath, tpath string) []checkResult {
-	PackagePath, TemplatesPath = ppath, tpath
-	OutputFormat = "json"
-	checkArgs()
-	return DoAll()
+func runTest(ppath, tpath string) *Result {
+	res, err := Check(context.Background(), &Config{
+		Package:   ppath,
+		Templates: tpath,
+	})
+	So(err, ShouldBeNil)
+	return res
+}
+
+// sortResults sorts results by template name, since their order is
+// not deterministic.
+func sortResults(res *Result) *Result {
+	sort.Slice(res.Templates, func(i, j int) bool {
+		return res.Templates[i].Template < res.Templates[j].Template
+	})
+	return res
 }
 
 func TestTmplCheck(t *testing.T) {
@@ -26,7 +44,808 @@ func TestTmplCheck(t *testing.T) {
 			b, err := ioutil.ReadFile(filepath.Join("testdata", "expected", "nil0.json"))
 			So(err, ShouldBeNil)
 			buf := bytes.Buffer{}
-			output(&buf, runTest(ppath, tpath))
+			So(Output(&buf, "json", runTest(ppath, tpath)), ShouldBeNil)
+			So(buf.String(), ShouldEqual, string(b))
+		})
+
+		Convey("parse error", func() {
+			b, err := ioutil.ReadFile(filepath.Join("testdata", "expected", "parseerr0.json"))
+			So(err, ShouldBeNil)
+			buf := bytes.Buffer{}
+			res := runTest(ppath, filepath.Join("testdata", "templates-parseerr"))
+			So(Output(&buf, "json", res), ShouldBeNil)
+			So(buf.String(), ShouldEqual, string(b))
+
+			// The other templates of the set are still checked.
+			checked := make(map[string][]string)
+			for _, t := range res.Templates {
+				for _, m := range t.Missing {
+					checked[t.Template] = append(checked[t.Template], m.MissingKey)
+				}
+			}
+			So(checked, ShouldResemble, map[string][]string{"root.html": {"Title"}})
+		})
+
+		Convey("nested fields", func() {
+			// Fields and methods with a result of the keys are passed, and
+			// map values, methods of empty interfaces and methods with no
+			// result are not.
+			b, err := ioutil.ReadFile(filepath.Join("testdata", "expected", "nested0.json"))
+			So(err, ShouldBeNil)
+			buf := bytes.Buffer{}
+			res := runTest("github.com/go-web-framework/tmplcheck/testdata/nested/src", filepath.Join("testdata", "nested", "templates"))
+			So(Output(&buf, "json", res), ShouldBeNil)
+			So(buf.String(), ShouldEqual, string(b))
+
+			var missing []string
+			for _, m := range res.Templates[0].Missing {
+				missing = append(missing, strings.Join(m.TemplateIdent.Idents, "."))
+			}
+			So(missing, ShouldResemble, []string{"Author.Nmae", "Author.Print", "Meta.lang", "Any.Name"})
+		})
+
+		Convey("unverifiable", func() {
+			results := runTest("github.com/go-web-framework/tmplcheck/testdata/unverifiable", tpath)
+			reasons := make(map[string][]string)
+			for _, r := range results.Templates {
+				So(r.Missing, ShouldBeEmpty)
+				for _, u := range r.Unverifiable {
+					reasons[r.Template] = append(reasons[r.Template], u.Reason)
+				}
+			}
+			So(reasons, ShouldResemble, map[string][]string{
+				"root.html": {errUnsupportedArgs.Error()},
+				"":          {errDynamicName.Error()},
+			})
+		})
+
+		Convey("errors are returned", func() {
+			_, err := Check(context.Background(), &Config{Templates: tpath})
+			So(err, ShouldNotBeNil)
+
+			_, err = Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/nonexistent",
+				Templates: tpath,
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("severities can be overridden", func() {
+			check := func(cfg *Config) []Severity {
+				cfg.Package, cfg.Templates = ppath, tpath
+				res, err := Check(context.Background(), cfg)
+				So(err, ShouldBeNil)
+				var ret []Severity
+				for _, r := range res.Templates {
+					for _, m := range r.Missing {
+						ret = append(ret, m.Severity)
+					}
+				}
+				return ret
+			}
+
+			So(check(&Config{}), ShouldResemble, []Severity{SeverityError, SeverityError, SeverityError})
+			So(check(&Config{
+				Severity: map[string]Severity{RuleMissingKey: SeverityInfo},
+			}), ShouldResemble, []Severity{SeverityInfo, SeverityInfo, SeverityInfo})
+			So(check(&Config{
+				Severity: map[string]Severity{RuleMissingKey: SeverityInfo},
+				Overrides: []Override{
+					{Dir: ".", Severity: map[string]Severity{RuleMissingKey: SeverityWarning}},
+					{Dir: "other", Severity: map[string]Severity{RuleMissingKey: SeverityError}},
+				},
+			}), ShouldResemble, []Severity{SeverityWarning, SeverityWarning, SeverityWarning})
+			So(check(&Config{
+				Severity: map[string]Severity{RuleMissingKey: SeverityOff},
+			}), ShouldBeEmpty)
+
+			_, err := Check(context.Background(), &Config{
+				Package:   ppath,
+				Templates: tpath,
+				Severity:  map[string]Severity{"no-such-rule": SeverityOff},
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("templates can be included and ignored", func() {
+			templates := func(cfg *Config) []string {
+				cfg.Package, cfg.Templates = ppath, filepath.Join("testdata", "tricky", "templates")
+				res, err := Check(context.Background(), cfg)
+				So(err, ShouldBeNil)
+				var ret []string
+				for _, r := range sortResults(res).Templates {
+					if r.Unknown == nil {
+						ret = append(ret, r.Template)
+					}
+				}
+				return ret
+			}
+
+			So(templates(&Config{Include: []string{"k*.html", "chain.html"}}), ShouldResemble, []string{"chain.html", "keyed.html"})
+			So(templates(&Config{Ignore: []string{"**/*.html"}}), ShouldBeEmpty)
+			So(templates(&Config{Ignore: []string{"[bc]*"}}), ShouldResemble, []string{"include.html", "keyed.html"})
+		})
+
+		Convey("globs", func() {
+			for _, tt := range []struct {
+				pattern, path string
+				want          bool
+			}{
+				{"*.html", "a/b.html", true},
+				{"*.html", "a/b.txt", false},
+				{"a/*.html", "a/b.html", true},
+				{"a/*.html", "a/b/c.html", false},
+				{"a/**/*.html", "a/b.html", true},
+				{"a/**/*.html", "a/b/c/d.html", true},
+				{"**", "a/b", true},
+				{"a/**", "b/c", false},
+			} {
+				So(matchGlob(tt.pattern, tt.path), ShouldEqual, tt.want)
+			}
+		})
+
+		Convey("suppressions", func() {
+			res := sortResults(runTest(
+				"github.com/go-web-framework/tmplcheck/testdata/suppress/src",
+				filepath.Join("testdata", "suppress", "templates"),
+			))
+			missing := make(map[string][]string)
+			unused := make(map[string][]string)
+			for _, r := range res.Templates {
+				So(r.Unverifiable, ShouldBeEmpty)
+				for _, m := range r.Missing {
+					missing[r.Template] = append(missing[r.Template], m.MissingKey)
+				}
+				for _, u := range r.Unused {
+					unused[r.Template] = append(unused[r.Template], fmt.Sprintf("%s:%d %s", u.Suppression.Path, u.Suppression.Line, u.Suppression.Rule))
+				}
+			}
+			// The directive at the end of line 22 does not apply to the
+			// call on line 23, and the one on line 25 applies to no call.
+			So(missing, ShouldResemble, map[string][]string{
+				"body.html": {"Body"},
+				"page.html": {"Name", "Secret"},
+			})
+			So(unused, ShouldResemble, map[string][]string{
+				"":          {"github.com/go-web-framework/tmplcheck/testdata/suppress/src/main.go:25 TC003"},
+				"body.html": {"github.com/go-web-framework/tmplcheck/testdata/suppress/src/main.go:22 ", "body.html:1 TC0001"},
+				"page.html": {"page.html:7 TC007"},
+			})
+			for _, u := range res.Templates[0].Unused {
+				So(u.finding().Go.Line, ShouldEqual, 25)
+				So(u.finding().Template.IsValid(), ShouldBeFalse)
+			}
+		})
+
+		Convey("directives", func() {
+			So(parseDirective("tmplcheck:ignore"), ShouldResemble, &Suppression{})
+			So(parseDirective(" tmplcheck:ignore missing-key  set by the layout "), ShouldResemble, &Suppression{Rule: RuleMissingKey, Reason: "set by the layout"})
+			So(parseDirective("tmplcheck:ignore the layout sets it"), ShouldResemble, &Suppression{Reason: "the layout sets it"})
+			So(parseDirective("tmplcheck:ignore TC0001 set by the layout"), ShouldResemble, &Suppression{Rule: "TC0001", Reason: "set by the layout"})
+			So(parseDirective("tmplcheck:ignore missing-keys"), ShouldResemble, &Suppression{Rule: "missing-keys"})
+			So(parseDirective("tmplcheck:ignore TC0001").suppresses(RuleMissingKey), ShouldBeFalse)
+			So(UnusedSuppression{Suppression: Suppression{Rule: "TC0001"}}.message(), ShouldEqual, `unknown rule "TC0001" in tmplcheck:ignore directive`)
+			So(parseDirective("tmplcheck:ignored"), ShouldBeNil)
+			So(parseDirective("a comment"), ShouldBeNil)
+		})
+
+		Convey("baselines", func() {
+			run := func() *Result {
+				return runTest(
+					"github.com/go-web-framework/tmplcheck/testdata/tricky/src",
+					filepath.Join("testdata", "tricky", "templates"),
+				)
+			}
+			count := func(res *Result) int {
+				n := 0
+				for _, r := range res.Templates {
+					n += len(r.Missing) + len(r.Unverifiable) + len(r.Unused)
+					if r.ParseErr != nil {
+						n++
+					}
+				}
+				return n
+			}
+
+			res := run()
+			total := count(res)
+			So(total, ShouldBeGreaterThan, 0)
+			b := NewBaseline(res)
+			So(count(res), ShouldEqual, total)
+
+			path := filepath.Join(t.TempDir(), "baseline.json")
+			So(b.WriteFile(path), ShouldBeNil)
+			b, err := ReadBaseline(path)
+			So(err, ShouldBeNil)
+
+			res = run()
+			So(b.Filter(res), ShouldBeEmpty)
+			So(count(res), ShouldEqual, 0)
+
+			// A finding that is no longer in the result is fixed.
+			b.Findings[0].Count++
+			res = run()
+			fixed := b.Filter(res)
+			So(count(res), ShouldEqual, 0)
+			So(fixed, ShouldHaveLength, 1)
+			So(fixed[0].Count, ShouldEqual, 1)
+			So(fixed[0].Fingerprint, ShouldEqual, b.Findings[0].Fingerprint)
+		})
+
+		Convey("fingerprints do not depend on lines", func() {
+			e := MissingError{
+				Usage:         Usage{Path: "main.go", Line: 10, Expr: `set.Execute("a.html", w, nil)`},
+				TemplateIdent: TemplateIdent{Path: "a.html", Line: 3, Col: 5, LineText: "  {{.Title}}"},
+				MissingKey:    "Title",
+			}
+			moved := e
+			moved.Usage.Line, moved.TemplateIdent.Line, moved.TemplateIdent.LineText = 12, 4, "{{.Title}}"
+			So(moved.Fingerprint(), ShouldEqual, e.Fingerprint())
+
+			other := e
+			other.MissingKey = "Body"
+			So(other.Fingerprint(), ShouldNotEqual, e.Fingerprint())
+
+			// Go source files of the same name in different directories.
+			unknown := UnknownTemplate{Usage: Usage{Path: "cmd/a/main.go", Template: "a.html", Expr: e.Usage.Expr}}
+			elsewhere := unknown
+			elsewhere.Usage.Path = "cmd/b/main.go"
+			So(elsewhere.Fingerprint(), ShouldNotEqual, unknown.Fingerprint())
+		})
+
+		Convey("rules", func() {
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/rules/src",
+				Templates: filepath.Join("testdata", "rules", "templates"),
+				Ignore:    []string{"ignored.html"},
+			})
+			So(err, ShouldBeNil)
+			found := make(map[string][]string)
+			for _, r := range sortResults(res).Templates {
+				for _, f := range r.Findings() {
+					if f.Rule.ID != RuleMissingKey {
+						found[r.Template] = append(found[r.Template], f.Rule.ID+" "+f.Message)
+					}
+				}
+			}
+			So(found, ShouldResemble, map[string][]string{
+				"page.html": {
+					`TC002 set.Execute passes "Extra", which is not used by the template`,
+					`TC004 "user" is an unexported field of main.page, passed by set.Execute`,
+					`TC005 wrong number of arguments for len: want 1, got 2`,
+					`TC005 wrong number of arguments for not: want 1, got 2`,
+				},
+				"pgae.html": {
+					`TC003 set.Execute executes unknown template "pgae.html"; did you mean "page.html"?`,
+				},
+			})
+
+			So(LookupRule("missing-key"), ShouldEqual, LookupRule("tc001"))
+			So(LookupRule("TC999"), ShouldBeNil)
+			for _, r := range Rules {
+				So(r.Explain(), ShouldStartWith, r.ID+" "+r.Name)
+			}
+		})
+
+		Convey("sarif output", func() {
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/rules/src",
+				Templates: filepath.Join("testdata", "rules", "templates"),
+				Ignore:    []string{"ignored.html"},
+				Severity:  map[string]Severity{"missing-key": SeverityOff},
+			})
+			So(err, ShouldBeNil)
+			res.Base = "testdata"
+			buf := bytes.Buffer{}
+			So(Output(&buf, "sarif", sortResults(res)), ShouldBeNil)
+
+			var log sarifLog
+			So(json.Unmarshal(buf.Bytes(), &log), ShouldBeNil)
+			So(log.Version, ShouldEqual, "2.1.0")
+			So(log.Runs, ShouldHaveLength, 1)
+			run := log.Runs[0]
+			So(run.Tool.Driver.Rules, ShouldHaveLength, len(Rules))
+
+			found := make(map[string]sarifResult)
+			for _, r := range run.Results {
+				So(r.PartialFingerprints[sarifFingerprint], ShouldNotBeEmpty)
+				So(run.Tool.Driver.Rules[r.RuleIndex].ID, ShouldEqual, r.RuleID)
+				found[r.RuleID] = r
+			}
+
+			unexported := found[RuleUnexportedField]
+			So(unexported.Level, ShouldEqual, "error")
+			So(unexported.Locations[0].PhysicalLocation.ArtifactLocation, ShouldResemble, sarifArtifactLocation{
+				URI: "rules/templates/page.html", URIBaseID: sarifBaseID,
+			})
+			So(unexported.RelatedLocations, ShouldHaveLength, 1)
+			So(unexported.RelatedLocations[0].PhysicalLocation.ArtifactLocation.URI, ShouldEqual, "rules/src/main.go")
+			So(unexported.RelatedLocations[0].PhysicalLocation.Region.StartLine, ShouldBeGreaterThan, 0)
+
+			unused := found[RuleUnusedKey]
+			So(unused.Level, ShouldEqual, "note")
+			So(unused.Locations[0].PhysicalLocation.ArtifactLocation.URI, ShouldEqual, "rules/src/main.go")
+			So(unused.RelatedLocations, ShouldBeEmpty)
+		})
+
+		Convey("junit and checkstyle output", func() {
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/rules/src",
+				Templates: filepath.Join("testdata", "rules", "templates"),
+				Ignore:    []string{"ignored.html"},
+			})
+			So(err, ShouldBeNil)
+			res.Base = "testdata"
+			sortResults(res)
+
+			buf := bytes.Buffer{}
+			So(Output(&buf, "junit", res), ShouldBeNil)
+			var suites junitTestSuites
+			So(xml.Unmarshal(buf.Bytes(), &suites), ShouldBeNil)
+			So(suites.Suites, ShouldHaveLength, len(res.Templates))
+			So(suites.Failures, ShouldEqual, len(res.Findings()))
+			page := suites.Suites[0]
+			So(page.Name, ShouldEqual, "page.html")
+			So(page.Failures, ShouldEqual, len(res.Templates[0].Findings()))
+			So(page.Cases[0].Failure.Type, ShouldEqual, RuleMissingKey)
+			So(page.Cases[0].Failure.Text, ShouldContainSubstring, "rules/templates/page.html:")
+			So(page.Cases[0].Failure.Text, ShouldContainSubstring, "rules/src/main.go:")
+
+			buf.Reset()
+			So(Output(&buf, "checkstyle", res), ShouldBeNil)
+			var cs checkstyleResult
+			So(xml.Unmarshal(buf.Bytes(), &cs), ShouldBeNil)
+			var names []string
+			for _, f := range cs.Files {
+				names = append(names, f.Name)
+			}
+			So(names, ShouldResemble, []string{"rules/src/main.go", "rules/templates/page.html"})
+			So(cs.Files[0].Errors[0].Source, ShouldStartWith, "tmplcheck.TC00")
+		})
+
+		Convey("plain output", func() {
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/rules/src",
+				Templates: filepath.Join("testdata", "rules", "templates"),
+				Include:   []string{"page.html"},
+				Severity:  map[string]Severity{"missing-key": SeverityOff, "unused-key": SeverityOff},
+			})
+			So(err, ShouldBeNil)
+			for i := range res.Templates {
+				res.Templates[i].Filter(func(f Finding) bool { return f.Rule.ID == RuleUnexportedField })
+			}
+
+			buf := bytes.Buffer{}
+			So(Output(&buf, "plain", res), ShouldBeNil)
+			So(buf.String(), ShouldStartWith, "testdata/rules/templates/page.html:2:6: ")
+			So(buf.String(), ShouldEndWith, "(testdata/rules/src/main.go:24:2) [TC004]\n")
+
+			buf.Reset()
+			So((&PlainReporter{Snippets: true}).Report(&buf, res), ShouldBeNil)
+			lines := strings.Split(buf.String(), "\n")
+			So(lines, ShouldHaveLength, 7)
+			So(lines[1], ShouldEqual, " 2 | <p>{{.user.Name}}</p>")
+			So(lines[2], ShouldEqual, "   |      ^~~~~~~~~~")
+			So(lines[3], ShouldEqual, "  --> testdata/rules/src/main.go:24:2")
+			So(lines[4], ShouldStartWith, " 24 | \tset.Execute(")
+		})
+
+		Convey("template output", func() {
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/rules/src",
+				Templates: filepath.Join("testdata", "rules", "templates"),
+				Include:   []string{"page.html"},
+			})
+			So(err, ShouldBeNil)
+			r, err := NewTemplateReporter(`{{.Rule}} {{.Template.Name}} {{.Template.File}}:{{.Template.Line}} {{.Key}} {{.Source.File}}:{{.Source.Line}} {{.Source.Call}}`)
+			So(err, ShouldBeNil)
+			buf := bytes.Buffer{}
+			So(r.Report(&buf, sortResults(res)), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "TC004 page.html testdata/rules/templates/page.html:2 user testdata/rules/src/main.go:24 set.Execute\n")
+			So(buf.String(), ShouldContainSubstring, "TC002 page.html :0 Extra testdata/rules/src/main.go:24 set.Execute\n")
+
+			_, err = NewTemplateReporter("{{.Rule")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("html output", func() {
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/rules/src",
+				Templates: filepath.Join("testdata", "rules", "templates"),
+				Include:   []string{"page.html"},
+			})
+			So(err, ShouldBeNil)
+			res.Base = "testdata"
+			buf := bytes.Buffer{}
+			So(Output(&buf, "html", res), ShouldBeNil)
+			out := buf.String()
+			So(out, ShouldStartWith, "<!DOCTYPE html>")
+			So(out, ShouldContainSubstring, `<td title="a template uses an unexported field of the data">TC004 unexported-field</td><td class="n">1</td>`)
+			So(out, ShouldContainSubstring, `<span class="mark error" tabindex="0">`)
+			So(out, ShouldContainSubstring, `<h3 id="t0-c0"><code>rules/src/main.go:24</code></h3>`)
+			So(out, ShouldNotContainSubstring, "http")
+		})
+
+		Convey("gitlab and github output", func() {
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/rules/src",
+				Templates: filepath.Join("testdata", "rules", "templates"),
+				Include:   []string{"page.html"},
+			})
+			So(err, ShouldBeNil)
+			res.Base = "testdata"
+			sortResults(res)
+
+			buf := bytes.Buffer{}
+			So(Output(&buf, "gitlab", res), ShouldBeNil)
+			var issues []gitlabIssue
+			So(json.Unmarshal(buf.Bytes(), &issues), ShouldBeNil)
+			So(issues, ShouldHaveLength, len(res.Findings()))
+			fingerprints := make(map[string]bool)
+			for _, e := range issues {
+				fingerprints[e.Fingerprint] = true
+				So(e.Location.Lines.Begin, ShouldBeGreaterThan, 0)
+			}
+			So(fingerprints, ShouldHaveLength, len(issues))
+			So(issues[0].CheckName, ShouldEqual, "TC001 missing-key")
+			So(issues[0].Severity, ShouldEqual, "critical")
+			So(issues[0].Location.Path, ShouldEqual, "rules/templates/page.html")
+
+			buf.Reset()
+			So(Output(&buf, "github", res), ShouldBeNil)
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			So(lines, ShouldHaveLength, len(issues))
+			So(lines[0], ShouldStartWith, "::error title=TC001 missing-key,file=rules/templates/page.html,line=2,col=6,endLine=2,endColumn=16::")
+			So(lines[0], ShouldEndWith, "::\"Name\" is missing from the data passed by set.Execute (rules/src/main.go:24:2)")
+			So(buf.String(), ShouldContainSubstring, "::notice title=TC002 unused-key,file=rules/src/main.go,line=24,col=2::")
+			So(githubEscapeProperty("a,b:c%\n"), ShouldEqual, "a%2Cb%3Ac%25%0A")
+		})
+
+		Convey("reporters can be registered", func() {
+			RegisterReporter("count", ReporterFunc(func(w io.Writer, res *Result) error {
+				_, err := fmt.Fprintln(w, len(res.Findings()))
+				return err
+			}))
+			defer func() {
+				delete(reporters, "count")
+				Formats = Formats[:len(Formats)-1]
+			}()
+			So(Formats, ShouldContain, "count")
+			buf := bytes.Buffer{}
+			So(Output(&buf, "count", &Result{}), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "0\n")
+			So(Output(&buf, "no-such-format", &Result{}), ShouldNotBeNil)
+		})
+
+		Convey("sorted and grouped results", func() {
+			cfg := &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/grouped/src",
+				Templates: filepath.Join("testdata", "grouped", "templates"),
+			}
+			res, err := Check(context.Background(), cfg)
+			So(err, ShouldBeNil)
+			So(res.Templates, ShouldHaveLength, 1)
+			var flat []string
+			for _, e := range res.Templates[0].Missing {
+				flat = append(flat, fmt.Sprintf("%d:%d %d", e.TemplateIdent.Line, e.TemplateIdent.Col, e.Usage.Line))
+			}
+			So(flat, ShouldResemble, []string{"2:6 23", "2:6 24", "3:17 23", "3:17 24"})
+
+			again, err := Check(context.Background(), cfg)
+			So(err, ShouldBeNil)
+			var first, second bytes.Buffer
+			So(Output(&first, "json", res), ShouldBeNil)
+			So(Output(&second, "json", again), ShouldBeNil)
+			So(second.String(), ShouldEqual, first.String())
+
+			res.Group()
+			r := res.Templates[0]
+			So(r.Missing, ShouldHaveLength, 1)
+			e := r.Missing[0]
+			So(e.TemplateIdents, ShouldHaveLength, 2)
+			So(e.Usages, ShouldHaveLength, 2)
+			So(e.TemplateIdent, ShouldResemble, e.TemplateIdents[0])
+			So(e.Fingerprint(), ShouldEqual, again.Templates[0].Missing[0].Fingerprint())
+			So(e.message(), ShouldEqual, `"Name", used 2 times, is missing from the data passed by set.Execute and 1 other call`)
+
+			buf := bytes.Buffer{}
+			So(Output(&buf, "plain", res), ShouldBeNil)
+			So(strings.Split(buf.String(), "\n"), ShouldResemble, []string{
+				`testdata/grouped/templates/page.html:2:6: "Name", used 2 times, is missing from the data passed by set.Execute and 1 other call (testdata/grouped/src/main.go:23:2) [TC001]`,
+				"\ttestdata/grouped/templates/page.html:3:17: also used here",
+				"\ttestdata/grouped/src/main.go:24:2: also not passed by set.Execute",
+				"",
+			})
+
+			buf.Reset()
+			So(Output(&buf, "json", res), ShouldBeNil)
+			var out []struct {
+				Missing []struct {
+					Templates []json.RawMessage `json:"templates"`
+					Sources   []json.RawMessage `json:"sources"`
+				} `json:"missing"`
+			}
+			So(json.Unmarshal(buf.Bytes(), &out), ShouldBeNil)
+			So(out[0].Missing[0].Templates, ShouldHaveLength, 2)
+			So(out[0].Missing[0].Sources, ShouldHaveLength, 2)
+
+			// Formats with one position for each finding report every use
+			// and call of a grouped finding.
+			res.Base = "testdata"
+			buf.Reset()
+			So(Output(&buf, "gitlab", res), ShouldBeNil)
+			var issues []gitlabIssue
+			So(json.Unmarshal(buf.Bytes(), &issues), ShouldBeNil)
+			var locations []string
+			for _, e := range issues {
+				locations = append(locations, fmt.Sprintf("%s:%d %s", e.Location.Path, e.Location.Lines.Begin, e.Description))
+			}
+			So(locations, ShouldResemble, []string{
+				`grouped/templates/page.html:2 "Name" is missing from the data passed by set.Execute (grouped/src/main.go:23:2)`,
+				`grouped/templates/page.html:2 "Name" is missing from the data passed by set.Execute (grouped/src/main.go:24:2)`,
+				`grouped/templates/page.html:3 "Name" is missing from the data passed by set.Execute (grouped/src/main.go:23:2)`,
+				`grouped/templates/page.html:3 "Name" is missing from the data passed by set.Execute (grouped/src/main.go:24:2)`,
+			})
+			So(issues[0].Fingerprint, ShouldEqual, again.Templates[0].Missing[0].Fingerprint())
+
+			buf.Reset()
+			So(Output(&buf, "github", res), ShouldBeNil)
+			So(strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), ShouldResemble, []string{
+				`::error title=TC001 missing-key,file=grouped/templates/page.html,line=2,col=6,endLine=2,endColumn=11::"Name" is missing from the data passed by set.Execute (grouped/src/main.go:23:2)`,
+				`::error title=TC001 missing-key,file=grouped/templates/page.html,line=2,col=6,endLine=2,endColumn=11::"Name" is missing from the data passed by set.Execute (grouped/src/main.go:24:2)`,
+				`::error title=TC001 missing-key,file=grouped/templates/page.html,line=3,col=17,endLine=3,endColumn=22::"Name" is missing from the data passed by set.Execute (grouped/src/main.go:23:2)`,
+				`::error title=TC001 missing-key,file=grouped/templates/page.html,line=3,col=17,endLine=3,endColumn=22::"Name" is missing from the data passed by set.Execute (grouped/src/main.go:24:2)`,
+			})
+
+			buf.Reset()
+			So(Output(&buf, "checkstyle", res), ShouldBeNil)
+			var cs checkstyleResult
+			So(xml.Unmarshal(buf.Bytes(), &cs), ShouldBeNil)
+			So(cs.Files, ShouldHaveLength, 1)
+			So(cs.Files[0].Name, ShouldEqual, "grouped/templates/page.html")
+			var errs []string
+			for _, e := range cs.Files[0].Errors {
+				errs = append(errs, fmt.Sprintf("%d:%d %s", e.Line, e.Column, e.Message))
+			}
+			So(errs, ShouldResemble, []string{
+				`2:6 "Name" is missing from the data passed by set.Execute`,
+				`2:6 "Name" is missing from the data passed by set.Execute`,
+				`3:17 "Name" is missing from the data passed by set.Execute`,
+				`3:17 "Name" is missing from the data passed by set.Execute`,
+			})
+			So(res.Templates[0].Missing, ShouldHaveLength, 1)
+
+			res.Group()
+			So(res.Templates[0].Missing, ShouldHaveLength, 1)
+			So(res.Templates[0].Missing[0].TemplateIdents, ShouldHaveLength, 2)
+			So(res.Templates[0].Missing[0].Usages, ShouldHaveLength, 2)
+		})
+
+		Convey("grouped by field chain", func() {
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/chains/src",
+				Templates: filepath.Join("testdata", "chains", "templates"),
+			})
+			So(err, ShouldBeNil)
+			res.Base = "testdata"
+			describe := func(fs []Finding) []string {
+				var ret []string
+				for _, f := range fs {
+					ret = append(ret, fmt.Sprintf("%d:%d %d %s", f.Template.Line, f.Template.Col, f.Go.Line, f.Message))
+				}
+				return ret
+			}
+			flat := describe(res.Findings())
+			So(flat, ShouldResemble, []string{
+				`1:6 28 "Name" is missing from the data passed by set.Execute`,
+				`1:21 27 "Author" is missing from the data passed by set.Execute`,
+				`1:21 28 "Name" is missing from the data passed by set.Execute`,
+				`2:6 27 "Author" is missing from the data passed by set.Execute`,
+				`2:6 28 "Name" is missing from the data passed by set.Execute`,
+			})
+
+			res.Group()
+			So(describe(res.Findings()), ShouldResemble, []string{
+				`1:6 28 "Name" is missing from the data passed by set.Execute`,
+				`1:21 27 "Author", used 2 times, is missing from the data passed by set.Execute`,
+				`1:21 28 "Name", used 2 times, is missing from the data passed by set.Execute`,
+			})
+			So(describe(res.Templates[0].flatFindings()), ShouldResemble, flat)
+
+			buf := bytes.Buffer{}
+			So(Output(&buf, "github", res), ShouldBeNil)
+			So(strings.Count(buf.String(), "\n"), ShouldEqual, len(flat))
+
+			// Only the findings that were merged are split again, not every
+			// use with every call.
+			u1, u2 := Usage{Path: "main.go", Line: 1}, Usage{Path: "main.go", Line: 2}
+			t1 := TemplateIdent{Path: "page.html", Line: 1, Idents: []string{"Title"}}
+			t2 := TemplateIdent{Path: "page.html", Line: 2, Idents: []string{"Title"}}
+			r := TemplateResult{Missing: []MissingError{
+				{Usage: u1, TemplateIdent: t1, MissingKey: "Title"},
+				{Usage: u2, TemplateIdent: t1, MissingKey: "Title"},
+				{Usage: u1, TemplateIdent: t2, MissingKey: "Title"},
+			}}
+			r.Group()
+			So(r.Missing, ShouldHaveLength, 1)
+			So(describe(r.flatFindings()), ShouldResemble, []string{
+				`1:0 1 "Title" is missing from the data passed by .`,
+				`1:0 2 "Title" is missing from the data passed by .`,
+				`2:0 1 "Title" is missing from the data passed by .`,
+			})
+		})
+
+		Convey("suggestions", func() {
+			So(editDistance("Titel", "Title"), ShouldEqual, 1)
+			So(editDistance("kitten", "sitting"), ShouldEqual, 3)
+			So(suggest("userName", []string{"UserName", "Username", "Name"}), ShouldResemble, []string{"UserName", "Username"})
+			So(suggest("Titel", []string{"Title", "Items", "Tile", "Titel", "Tite", "TITEL"}), ShouldResemble, []string{"TITEL", "Tite", "Title"})
+			So(suggest("ab", []string{"ac", "AB"}), ShouldResemble, []string{"AB"})
+			So(DidYouMean(nil), ShouldEqual, "")
+			So(DidYouMean([]string{"A", "B", "C"}), ShouldEqual, `; did you mean "A", "B" or "C"?`)
+
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/suggest/src",
+				Templates: filepath.Join("testdata", "suggest", "templates"),
+			})
+			So(err, ShouldBeNil)
+			suggestions := make(map[string][]string)
+			for _, r := range res.Templates {
+				for _, e := range r.Missing {
+					suggestions[e.MissingKey] = e.Suggestions
+				}
+				for _, e := range r.Unknown {
+					suggestions[e.Usage.Template] = e.Suggestions
+				}
+			}
+			So(suggestions, ShouldResemble, map[string][]string{
+				"Titel":      {"Title"},
+				"userName":   {"UserName"},
+				"Nmae":       {"Name"},
+				"pages.html": {"page.html"},
+				// Methods of the data are passed, and fields it does not set
+				// are not.
+				"Sumary": {"Summary"},
+				"Autor":  nil,
+			})
+
+			// Following a suggestion fixes the finding.
+			path := filepath.Join("testdata", "suggest", "templates", "summary.html")
+			b, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			pt, perr := parseTemplate([]byte(strings.Replace(string(b), "Sumary", "Summary", 1)), "summary.html", &Config{})
+			So(perr, ShouldBeNil)
+			for _, r := range res.Templates {
+				if r.Template == "summary.html" {
+					var missing []string
+					for _, m := range check(pt.idents, []Usage{r.Missing[0].Usage}, make(map[*Suppression]bool)).Missing {
+						missing = append(missing, m.MissingKey)
+					}
+					So(missing, ShouldResemble, []string{"Autor"})
+				}
+			}
+
+			t, err := NewTemplateReporter(`{{.Key}} {{.Suggestions}} {{.Message}}`)
+			So(err, ShouldBeNil)
+			buf := bytes.Buffer{}
+			So(t.Report(&buf, res), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "Titel [Title] \"Titel\" is missing from the data passed by set.Execute; did you mean \"Title\"?\n")
+
+			buf.Reset()
+			So(Output(&buf, "json", res), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, `"suggestions": [`)
+		})
+
+		Convey("fixes", func() {
+			src := []byte("<h1>{{- .title -}}</h1>\r\n{{ if .title }}{{ .Author.Nmae }}{{ end }}\r\n")
+			pt, perr := parseTemplate(src, "page.html", &Config{})
+			So(perr, ShouldBeNil)
+			fixes := func(usages ...Usage) map[string][]Edit {
+				r := check(pt.idents, usages, make(map[*Suppression]bool))
+				r.Template = "page.html"
+				return (&Result{Templates: []TemplateResult{r}}).Fixes()
+			}
+
+			edits := fixes(Usage{Keys: []string{"Title", "Author"}}, Usage{Keys: []string{"Title", "Author", "Extra"}})
+			So(edits["page.html"], ShouldHaveLength, 2)
+			fixed, err := ApplyEdits(src, edits["page.html"])
+			So(err, ShouldBeNil)
+			So(string(fixed), ShouldEqual, "<h1>{{- .Title -}}</h1>\r\n{{ if .Title }}{{ .Author.Nmae }}{{ end }}\r\n")
+
+			// Not when a call passes the key, or the suggestions are ambiguous.
+			So(fixes(Usage{Keys: []string{"Title"}}, Usage{Keys: []string{"title"}}), ShouldBeEmpty)
+			So(fixes(Usage{Keys: []string{"Title", "TITLE"}}), ShouldBeEmpty)
+
+			_, err = ApplyEdits([]byte("{{.Title}}"), edits["page.html"])
+			So(err, ShouldNotBeNil)
+
+			buf := bytes.Buffer{}
+			So(WriteDiff(&buf, "page.html", src, fixed), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "--- a/page.html\n+++ b/page.html\n@@ -1,2 +1,2 @@\n"+
+				"-<h1>{{- .title -}}</h1>\r\n-{{ if .title }}{{ .Author.Nmae }}{{ end }}\r\n"+
+				"+<h1>{{- .Title -}}</h1>\r\n+{{ if .Title }}{{ .Author.Nmae }}{{ end }}\r\n")
+			buf.Reset()
+			So(WriteDiff(&buf, "page.html", src, src), ShouldBeNil)
+			So(buf.String(), ShouldBeEmpty)
+
+			var lines []string
+			for i := 1; i <= 20; i++ {
+				lines = append(lines, fmt.Sprint(i))
+			}
+			old := strings.Join(lines, "\n")
+			lines[1], lines[17] = "two", "eighteen"
+			buf.Reset()
+			So(WriteDiff(&buf, "n", []byte(old), []byte(strings.Join(lines, "\n"))), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "--- a/n\n+++ b/n\n"+
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n"+
+				"@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n\\ No newline at end of file\n")
+
+			res, err := Check(context.Background(), &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/suggest/src",
+				Templates: filepath.Join("testdata", "suggest", "templates"),
+			})
+			So(err, ShouldBeNil)
+			path := filepath.Join("testdata", "suggest", "templates", "page.html")
+			So(res.Fixes(), ShouldHaveLength, 2)
+			So(res.Fixes()[path], ShouldHaveLength, 3)
+			So(res.Fixes()[filepath.Join("testdata", "suggest", "templates", "summary.html")], ShouldResemble, []Edit{
+				{Path: "summary.html", Pos: 26, End: 32, Old: "Sumary", New: "Summary"},
+			})
+			b, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			fixed, err = ApplyEdits(b, res.Fixes()[path])
+			So(err, ShouldBeNil)
+			// Name is not a key passed by the call but a field of Author.
+			So(string(fixed), ShouldEqual, "<h1>{{.Title}}</h1>\n<p>{{.UserName}} {{.Author.Name}}</p>\n")
+
+			// The fixed template has no findings.
+			pt, perr = parseTemplate(fixed, "page.html", &Config{})
+			So(perr, ShouldBeNil)
+			for _, r := range res.Templates {
+				if r.Template == "page.html" {
+					So(check(pt.idents, []Usage{r.Missing[0].Usage}, make(map[*Suppression]bool)).Missing, ShouldBeEmpty)
+				}
+			}
+		})
+
+		Convey("positions", func() {
+			src := []byte("\xef\xbb\xbf<p>\r\nü {{.user.Name}}\r\n{{(.A).B.C}}")
+			index := newLineIndex(src)
+			line, col := index.position(len(bom))
+			So([]int{line, col}, ShouldResemble, []int{1, 1})
+			So(index.text(1), ShouldEqual, "<p>")
+			So(index.text(2), ShouldEqual, "ü {{.user.Name}}")
+
+			pt, perr := parseTemplate(src, "page.html", &Config{})
+			So(perr, ShouldBeNil)
+			var spans []string
+			for _, ti := range pt.idents {
+				spans = append(spans, fmt.Sprintf("%s %d:%d-%d:%d %s",
+					strings.Join(ti.Idents, "."), ti.Line, ti.Col, ti.EndLine, ti.EndCol, src[ti.Pos:ti.End]))
+			}
+			So(spans, ShouldResemble, []string{
+				"user.Name 2:5-2:15 .user.Name",
+				"A 3:4-3:6 .A",
+			})
+
+			dir, err := ioutil.TempDir("", "tmplcheck")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			So(os.MkdirAll(filepath.Join(dir, "cmd", "app"), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n"), 0644), ShouldBeNil)
+			So(modulePath(filepath.Join(dir, "cmd", "app", "main.go")), ShouldEqual, "cmd/app/main.go")
+			// The tree is in a GOPATH workspace, without a go.mod file.
+			So(modulePath(filepath.Join("testdata", "src", "hello.go")), ShouldEqual, "github.com/go-web-framework/tmplcheck/testdata/src/hello.go")
+		})
+
+		Convey("tricky inputs do not panic", func() {
+			b, err := ioutil.ReadFile(filepath.Join("testdata", "expected", "tricky0.json"))
+			So(err, ShouldBeNil)
+			buf := bytes.Buffer{}
+			So(Output(&buf, "json", sortResults(runTest(
+				"github.com/go-web-framework/tmplcheck/testdata/tricky/src",
+				filepath.Join("testdata", "tricky", "templates"),
+			))), ShouldBeNil)
 			So(buf.String(), ShouldEqual, string(b))
 		})
 	})
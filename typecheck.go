@@ -0,0 +1,268 @@
+package tmplcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/types"
+	"strconv"
+
+	tparse "text/template/parse"
+
+	"github.com/go-web-framework/tmplcheck/visit"
+)
+
+// UnexportedField is a field or method used in a template that is
+// unexported in the type of the data passed by an Execute call.
+type UnexportedField struct {
+	Usage         Usage
+	TemplateIdent TemplateIdent
+	Field         string // the unexported name
+	Type          string // type that has the field, such as main.page
+	Severity      Severity
+}
+
+func (e UnexportedField) MarshalJSON() ([]byte, error) {
+	type t struct {
+		Path    string `json:"file"`
+		Line    int    `json:"line"`
+		Col     int    `json:"col"`
+		EndLine int    `json:"end_line"`
+		EndCol  int    `json:"end_col"`
+	}
+
+	type s struct {
+		Path       string `json:"file"`
+		Line       int    `json:"line"`
+		Col        int    `json:"col"`
+		MethodCall string `json:"call"`
+	}
+
+	aux := struct {
+		Template t        `json:"template"`
+		Source   s        `json:"source"`
+		Field    string   `json:"field"`
+		Type     string   `json:"type"`
+		Rule     string   `json:"rule"`
+		Severity Severity `json:"severity"`
+	}{
+		t{
+			e.TemplateIdent.Path,
+			e.TemplateIdent.Line,
+			e.TemplateIdent.Col,
+			e.TemplateIdent.EndLine,
+			e.TemplateIdent.EndCol,
+		},
+		s{
+			e.Usage.Path,
+			e.Usage.Line,
+			e.Usage.Col,
+			e.Usage.Obj + "." + e.Usage.Call,
+		},
+		e.Field,
+		e.Type,
+		RuleUnexportedField,
+		e.Severity,
+	}
+
+	return json.Marshal(aux)
+}
+
+func (e UnexportedField) String() string {
+	return fmt.Sprintf(
+		"%d:%d: %suses unexported field %q of %s, passed by %s:%d: %s.%s [%s]",
+		e.TemplateIdent.Line, e.TemplateIdent.Col, e.Severity.label(),
+		e.Field, e.Type, e.Usage.Path, e.Usage.Line, e.Usage.Obj, e.Usage.Call, RuleUnexportedField,
+	)
+}
+
+func (e UnexportedField) message() string {
+	return fmt.Sprintf("%q is an unexported field of %s, passed by %s.%s", e.Field, e.Type, e.Usage.Obj, e.Usage.Call)
+}
+
+// FuncArgsError is a call of a predefined function with the wrong
+// number of arguments.
+type FuncArgsError struct {
+	TemplateIdent TemplateIdent // Idents is the function name
+	Func          string
+	Got           int
+	Want          string // such as "1" or "at least 2"
+	Severity      Severity
+}
+
+func (e FuncArgsError) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		Path     string   `json:"file"`
+		Line     int      `json:"line"`
+		Col      int      `json:"col"`
+		EndLine  int      `json:"end_line"`
+		EndCol   int      `json:"end_col"`
+		Func     string   `json:"func"`
+		Got      int      `json:"got"`
+		Want     string   `json:"want"`
+		Rule     string   `json:"rule"`
+		Severity Severity `json:"severity"`
+	}{
+		e.TemplateIdent.Path,
+		e.TemplateIdent.Line,
+		e.TemplateIdent.Col,
+		e.TemplateIdent.EndLine,
+		e.TemplateIdent.EndCol,
+		e.Func,
+		e.Got,
+		e.Want,
+		RuleBadFuncArgs,
+		e.Severity,
+	}
+
+	return json.Marshal(aux)
+}
+
+func (e FuncArgsError) String() string {
+	return fmt.Sprintf(
+		"%d:%d: %s%s [%s]",
+		e.TemplateIdent.Line, e.TemplateIdent.Col, e.Severity.label(), e.message(), RuleBadFuncArgs,
+	)
+}
+
+func (e FuncArgsError) message() string {
+	return fmt.Sprintf("wrong number of arguments for %s: want %s, got %d", e.Func, e.Want, e.Got)
+}
+
+// arity is the number of arguments of a predefined function.
+type arity struct {
+	min, max int // max is -1 for variadic functions
+}
+
+func (a arity) String() string {
+	switch {
+	case a.min == a.max:
+		return strconv.Itoa(a.min)
+	case a.max < 0:
+		return "at least " + strconv.Itoa(a.min)
+	default:
+		return fmt.Sprintf("%d to %d", a.min, a.max)
+	}
+}
+
+// funcArity is the number of arguments of the predefined functions of
+// text/template.
+var funcArity = map[string]arity{
+	"and":      {1, -1},
+	"call":     {1, -1},
+	"eq":       {2, -1},
+	"ge":       {2, 2},
+	"gt":       {2, 2},
+	"html":     {0, -1},
+	"index":    {1, -1},
+	"js":       {0, -1},
+	"le":       {2, 2},
+	"len":      {1, 1},
+	"lt":       {2, 2},
+	"ne":       {2, 2},
+	"not":      {1, 1},
+	"or":       {1, -1},
+	"print":    {0, -1},
+	"printf":   {1, -1},
+	"println":  {0, -1},
+	"slice":    {1, -1},
+	"urlquery": {0, -1},
+}
+
+// checkFuncArgs returns the error for the command if it calls a
+// predefined function with the wrong number of arguments, or nil. The
+// value of the previous command of a pipeline is passed as the last
+// argument.
+func checkFuncArgs(cmd *tparse.CommandNode, pipe *tparse.PipeNode) *FuncArgsError {
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+	id, ok := cmd.Args[0].(*tparse.IdentifierNode)
+	if !ok {
+		return nil
+	}
+	a, ok := funcArity[id.Ident]
+	if !ok {
+		return nil
+	}
+	got := len(cmd.Args) - 1
+	if pipe != nil && len(pipe.Cmds) != 0 && pipe.Cmds[0] != cmd {
+		got++
+	}
+	if got >= a.min && (a.max < 0 || got <= a.max) {
+		return nil
+	}
+	return &FuncArgsError{
+		TemplateIdent: TemplateIdent{Idents: []string{id.Ident}}, // position set by the caller
+		Func:          id.Ident,
+		Got:           got,
+		Want:          a.String(),
+	}
+}
+
+// unexportedFields returns the unexported fields used in the template
+// on the data passed by the usage. Fields are checked along chains such
+// as .User.email as far as their types are known.
+func unexportedFields(pt parsedTemplate, u Usage) []UnexportedField {
+	if u.Data == nil || pt.tree == nil {
+		return nil
+	}
+
+	var ret []UnexportedField
+	check := func(node tparse.Node, t types.Type, names []string) {
+		for _, name := range names {
+			next, obj := visit.Field(t, name)
+			if next == nil && obj == nil {
+				if unexported(t, name) {
+					ret = append(ret, UnexportedField{
+						Usage:         u,
+						TemplateIdent: pt.ident(node, names),
+						Field:         name,
+						Type:          types.TypeString(t, packageName),
+					})
+				}
+				return
+			}
+			if t = next; t == nil {
+				return
+			}
+		}
+	}
+
+	visit.Walk(pt.tree, u.Data, visit.Funcs{EnterFunc: func(node tparse.Node, sc *visit.Scope) error {
+		switch n := node.(type) {
+		case *tparse.FieldNode:
+			check(n, sc.Dot, n.Ident)
+		case *tparse.ChainNode:
+			check(n, sc.TypeOf(n.Node), n.Field)
+		case *tparse.VariableNode:
+			if v, ok := sc.Lookup(n.Ident[0]); ok {
+				check(n, v.Type, n.Ident[1:])
+			}
+		}
+		return nil
+	}})
+	return ret
+}
+
+// unexported reports whether name is an unexported field or method of
+// the type, which is a named struct type or a pointer to one.
+func unexported(t types.Type, name string) bool {
+	if t == nil {
+		return false
+	}
+	named := t
+	if p, ok := t.(*types.Pointer); ok {
+		named = p.Elem()
+	}
+	n, ok := named.(*types.Named)
+	if !ok || n.Obj().Pkg() == nil {
+		return false
+	}
+	obj, _, _ := types.LookupFieldOrMethod(t, true, n.Obj().Pkg(), name)
+	return obj != nil && !obj.Exported()
+}
+
+// packageName qualifies types by package name, such as main.page.
+func packageName(p *types.Package) string {
+	return p.Name()
+}
@@ -0,0 +1,266 @@
+package visit
+
+import (
+	"go/types"
+	"sort"
+	"text/template/parse"
+)
+
+// TypeOf returns the type of the value of n in the scope, or nil if
+// unknown. n is typically an argument of a command, a command or a
+// pipeline.
+func (s *Scope) TypeOf(n parse.Node) types.Type {
+	switch n := n.(type) {
+	case *parse.PipeNode:
+		if n == nil || len(n.Cmds) == 0 {
+			return nil
+		}
+		// The value of a pipeline is the value of its last command.
+		return s.TypeOf(n.Cmds[len(n.Cmds)-1])
+	case *parse.CommandNode:
+		if len(n.Args) == 0 {
+			return nil
+		}
+		if id, ok := n.Args[0].(*parse.IdentifierNode); ok {
+			return s.funcType(id.Ident, n.Args[1:])
+		}
+		// Any remaining arguments are the arguments of a method.
+		return s.TypeOf(n.Args[0])
+	case *parse.FieldNode:
+		return FieldChain(s.Dot, n.Ident)
+	case *parse.ChainNode:
+		return FieldChain(s.TypeOf(n.Node), n.Field)
+	case *parse.VariableNode:
+		v, ok := s.Lookup(n.Ident[0])
+		if !ok {
+			return nil
+		}
+		return FieldChain(v.Type, n.Ident[1:])
+	case *parse.DotNode:
+		return s.Dot
+	case *parse.BoolNode:
+		return types.Typ[types.Bool]
+	case *parse.StringNode:
+		return types.Typ[types.String]
+	case *parse.NumberNode:
+		switch {
+		case n.IsInt:
+			return types.Typ[types.Int]
+		case n.IsFloat:
+			return types.Typ[types.Float64]
+		case n.IsComplex:
+			return types.Typ[types.Complex128]
+		}
+	case *parse.NilNode:
+		return types.Typ[types.UntypedNil]
+	}
+	return nil
+}
+
+// funcType returns the result type of calling the predefined function
+// name with args. It is nil for functions that are not predefined.
+func (s *Scope) funcType(name string, args []parse.Node) types.Type {
+	switch name {
+	case "not", "eq", "ne", "lt", "le", "gt", "ge":
+		return types.Typ[types.Bool]
+	case "len":
+		return types.Typ[types.Int]
+	case "html", "js", "print", "printf", "println", "urlquery":
+		return types.Typ[types.String]
+	case "index":
+		if len(args) == 0 {
+			return nil
+		}
+		t := s.TypeOf(args[0])
+		for range args[1:] {
+			t = elemType(t)
+		}
+		return t
+	case "slice":
+		if len(args) == 0 {
+			return nil
+		}
+		return s.TypeOf(args[0])
+	case "call":
+		if len(args) == 0 {
+			return nil
+		}
+		if t := s.TypeOf(args[0]); t != nil {
+			if sig, ok := t.Underlying().(*types.Signature); ok && sig.Results().Len() > 0 {
+				return sig.Results().At(0).Type()
+			}
+		}
+	}
+	return nil
+}
+
+// Field returns the type of the field, method or map value named name
+// on a value of type t, as evaluated by {{.Name}}. For methods, the type
+// is the type of the first result. obj is the *types.Var or *types.Func
+// for fields and methods, and nil for map values. Both are nil if t is
+// nil or has no such field.
+func Field(t types.Type, name string) (typ types.Type, obj types.Object) {
+	if t == nil {
+		return nil, nil
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		if m, ok := p.Elem().Underlying().(*types.Map); ok {
+			return m.Elem(), nil
+		}
+	}
+	if m, ok := t.Underlying().(*types.Map); ok {
+		return m.Elem(), nil
+	}
+
+	// A nil package only finds exported names, which are the only ones
+	// that can be used in templates.
+	o, _, _ := types.LookupFieldOrMethod(t, true, nil, name)
+	switch o := o.(type) {
+	case *types.Var:
+		return o.Type(), o
+	case *types.Func:
+		sig := o.Type().(*types.Signature)
+		if sig.Results().Len() == 0 {
+			return nil, o
+		}
+		return sig.Results().At(0).Type(), o
+	}
+	return nil, nil
+}
+
+// FieldChain returns the type of the chain of fields names on a value
+// of type t, such as .User.Email, or nil if unknown.
+func FieldChain(t types.Type, names []string) types.Type {
+	for _, name := range names {
+		t, _ = Field(t, name)
+		if t == nil {
+			return nil
+		}
+	}
+	return t
+}
+
+// Names returns the names of the exported fields, including promoted
+// ones, and methods that {{.Name}} can evaluate on a value of type t,
+// sorted. It is empty if t is nil or has none, as for maps, whose keys
+// are not known.
+func Names(t types.Type) []string {
+	if t == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, typ := range []types.Type{t, types.NewPointer(t)} {
+		ms := types.NewMethodSet(typ)
+		for i := 0; i < ms.Len(); i++ {
+			if obj := ms.At(i).Obj(); obj.Exported() {
+				seen[obj.Name()] = true
+			}
+		}
+	}
+
+	for _, f := range Fields(t) {
+		seen[f.Name()] = true
+	}
+
+	var ret []string
+	for name := range seen {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Fields returns the exported fields of a struct type, or a pointer to
+// one, including fields promoted from embedded structs. Shallower fields
+// come first, as they shadow deeper fields of the same name.
+func Fields(t types.Type) []*types.Var {
+	var ret []*types.Var
+	seen := make(map[types.Type]bool)
+	for level := []types.Type{t}; len(level) != 0; {
+		var next []types.Type
+		for _, t := range level {
+			if p, ok := t.Underlying().(*types.Pointer); ok {
+				t = p.Elem()
+			}
+			st, ok := t.Underlying().(*types.Struct)
+			if !ok || seen[t] {
+				continue
+			}
+			seen[t] = true
+			for i := 0; i < st.NumFields(); i++ {
+				f := st.Field(i)
+				if f.Exported() {
+					ret = append(ret, f)
+				}
+				if f.Embedded() {
+					next = append(next, f.Type())
+				}
+			}
+		}
+		level = next
+	}
+	return ret
+}
+
+// RangeTypes returns the types of the key and element when ranging
+// over a value of type t. A nil type means unknown or not applicable;
+// for instance, there is no key for a channel.
+func RangeTypes(t types.Type) (key, elem types.Type) {
+	if t == nil {
+		return nil, nil
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		return types.Typ[types.Int], u.Elem()
+	case *types.Array:
+		return types.Typ[types.Int], u.Elem()
+	case *types.Map:
+		return u.Key(), u.Elem()
+	case *types.Chan:
+		return nil, u.Elem()
+	case *types.Basic:
+		if u.Info()&types.IsInteger != 0 {
+			return nil, t
+		}
+	case *types.Signature:
+		// Iterator functions: func(yield func(K) bool) and
+		// func(yield func(K, V) bool).
+		if u.Params().Len() != 1 || u.Results().Len() != 0 {
+			return nil, nil
+		}
+		yield, ok := u.Params().At(0).Type().Underlying().(*types.Signature)
+		if !ok {
+			return nil, nil
+		}
+		switch yield.Params().Len() {
+		case 1:
+			return nil, yield.Params().At(0).Type()
+		case 2:
+			return yield.Params().At(0).Type(), yield.Params().At(1).Type()
+		}
+	}
+	return nil, nil
+}
+
+// elemType returns the type of an element of a value of type t, as
+// obtained by index.
+func elemType(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		return u.Elem()
+	case *types.Array:
+		return u.Elem()
+	case *types.Map:
+		return u.Elem()
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+/*
+Package visit traverses text/template parse trees while keeping track of
+the scope at each node: the type of dot, the variables in scope, the
+ancestors of the node and the name of the template that defines it.
+
+It is the traversal used by tmplcheck, exported so that custom checks
+can be written on top of it.
+
+Types are go/types types. The type of dot at the root of a tree is
+provided by the caller, typically the type of the data argument passed
+to Execute. A nil type means unknown; anything derived from an unknown
+type is also unknown.
+*/
+package visit
+
+import (
+	"errors"
+	"fmt"
+	"go/types"
+	"text/template/parse"
+)
+
+/*
+NOTES
+=====
+
+From the text/template package comment: "Actions"--data evaluations or control
+structures--are delimited by "{{" and "}}".
+
+From the documentation for text/template/parse:
+
+Pipeline nodes (type PipeNode) contains []*VariableNode and []*CommandNode, which are
+of interest to us to determine fields in use in a template.
+Also see: type FieldNode and type ChainNode.
+
+The following type contain a PipeNode directly:
+
+  * ActionNode
+  * BranchNode
+  * TemplateNode
+
+Other types contain or embed one of the above 3 types, thus indirectly containing a PipeNode:
+
+  * IfNode
+  * RangeNode
+  * WithNode
+
+ListNode is used for traversals. In addition to the Tree root, it is present in BranchNode.
+"{{else if}}" and "{{else with}}" are parsed as an IfNode or WithNode that is the only
+node in the ElseList of the outer node.
+
+The following types are leaves that contain no fields of interest:
+
+  * BreakNode and ContinueNode, for "{{break}}" and "{{continue}}" inside a range
+  * CommentNode, only present when the tree is parsed with the ParseComments mode
+
+Variables declared in the pipeline of an if, range or with are in scope
+until its {{end}}, including in the else branch. Variables declared in
+an action are in scope until the end of the enclosing list.
+*/
+
+// SkipChildren is used as a return value from Visitor.Enter to indicate
+// that the children of the node are to be skipped. Leave is still
+// called for the node. It is not returned as an error by any function.
+var SkipChildren = errors.New("skip children")
+
+// Visitor is called for each node of a tree. A non-nil error other
+// than SkipChildren stops the traversal and is returned by Walk.
+type Visitor interface {
+	// Enter is called for a node before its children are visited.
+	Enter(n parse.Node, s *Scope) error
+
+	// Leave is called for a node after its children are visited.
+	Leave(n parse.Node, s *Scope) error
+}
+
+// Funcs is a Visitor made of functions. A nil function is treated as
+// a function that returns nil.
+type Funcs struct {
+	EnterFunc func(n parse.Node, s *Scope) error
+	LeaveFunc func(n parse.Node, s *Scope) error
+}
+
+func (f Funcs) Enter(n parse.Node, s *Scope) error {
+	if f.EnterFunc == nil {
+		return nil
+	}
+	return f.EnterFunc(n, s)
+}
+
+func (f Funcs) Leave(n parse.Node, s *Scope) error {
+	if f.LeaveFunc == nil {
+		return nil
+	}
+	return f.LeaveFunc(n, s)
+}
+
+// UnknownNodeError is returned by Walk for a node it does not know how
+// to traverse. Enter has been called for the node.
+type UnknownNodeError struct {
+	Node parse.Node
+}
+
+func (e *UnknownNodeError) Error() string {
+	return fmt.Sprintf("visit: unknown node type %T", e.Node)
+}
+
+// Var is a variable in scope.
+type Var struct {
+	Name string     // name including the leading "$"
+	Type types.Type // nil if unknown
+
+	// Decl is the node declaring the variable. It is nil for "$".
+	Decl *parse.VariableNode
+}
+
+// Scope is the scope at a node.
+//
+// The Scope passed to a Visitor is reused during the traversal and is
+// only valid for the duration of the call. Use Copy to retain it.
+type Scope struct {
+	// Template is the name of the template that defines the node:
+	// the name in {{define}} or {{block}}, or the name of the tree.
+	Template string
+
+	// Dot is the type of dot. It is nil if unknown.
+	Dot types.Type
+
+	// Vars are the variables in scope, outermost first. The first
+	// variable is always "$".
+	Vars []Var
+
+	// Parents are the ancestors of the node, root first.
+	Parents []parse.Node
+}
+
+// Parent returns the parent of the node, or nil for the root.
+func (s *Scope) Parent() parse.Node {
+	if len(s.Parents) == 0 {
+		return nil
+	}
+	return s.Parents[len(s.Parents)-1]
+}
+
+// Enclosing returns the if, range and with nodes that enclose the
+// node, outermost first.
+func (s *Scope) Enclosing() []parse.Node {
+	var ret []parse.Node
+	for _, p := range s.Parents {
+		switch p.(type) {
+		case *parse.IfNode, *parse.RangeNode, *parse.WithNode:
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// Lookup returns the innermost variable with the name, which includes
+// the leading "$".
+func (s *Scope) Lookup(name string) (Var, bool) {
+	for i := len(s.Vars) - 1; i >= 0; i-- {
+		if s.Vars[i].Name == name {
+			return s.Vars[i], true
+		}
+	}
+	return Var{}, false
+}
+
+// Copy returns a copy of the scope that is not modified by the
+// traversal.
+func (s *Scope) Copy() *Scope {
+	c := *s
+	c.Vars = append([]Var(nil), s.Vars...)
+	c.Parents = append([]parse.Node(nil), s.Parents...)
+	return &c
+}
+
+// Walk traverses the tree in depth-first order, calling v.Enter and
+// v.Leave for each node. dot is the type of dot at the root of the
+// tree; it may be nil if unknown.
+func Walk(t *parse.Tree, dot types.Type, v Visitor) error {
+	if t.Root == nil {
+		return nil
+	}
+	w := &walker{
+		v: v,
+		s: Scope{
+			Template: t.Name,
+			Dot:      dot,
+			Vars:     []Var{{Name: "$", Type: dot}},
+		},
+	}
+	return w.walk(t.Root)
+}
+
+type walker struct {
+	v Visitor
+	s Scope
+}
+
+func (w *walker) walk(n parse.Node) error {
+	err := w.v.Enter(n, &w.s)
+	if err == SkipChildren {
+		return w.v.Leave(n, &w.s)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.s.Parents = append(w.s.Parents, n)
+	err = w.children(n)
+	w.s.Parents = w.s.Parents[:len(w.s.Parents)-1]
+	if err != nil {
+		return err
+	}
+
+	return w.v.Leave(n, &w.s)
+}
+
+// children walks the children of n, updating the scope as the
+// template would during execution.
+func (w *walker) children(n parse.Node) error {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		nvars := len(w.s.Vars)
+		for _, c := range n.Nodes {
+			if err := w.walk(c); err != nil {
+				return err
+			}
+		}
+		w.s.Vars = w.s.Vars[:nvars]
+	case *parse.ActionNode:
+		return w.walk(n.Pipe)
+	case *parse.ChainNode:
+		return w.walk(n.Node)
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			if err := w.walk(a); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return w.branch(&n.BranchNode)
+	case *parse.PipeNode:
+		typ := w.s.TypeOf(n)
+		for _, v := range n.Decl {
+			if err := w.walk(v); err != nil {
+				return err
+			}
+		}
+		for _, c := range n.Cmds {
+			if err := w.walk(c); err != nil {
+				return err
+			}
+		}
+		if !n.IsAssign {
+			for _, v := range n.Decl {
+				w.s.Vars = append(w.s.Vars, Var{Name: v.Ident[0], Type: typ, Decl: v})
+			}
+		}
+	case *parse.RangeNode:
+		return w.branch(&n.BranchNode)
+	case *parse.TemplateNode:
+		if n.Pipe != nil { // nil for {{template "name"}}
+			return w.walk(n.Pipe)
+		}
+	case *parse.WithNode:
+		return w.branch(&n.BranchNode)
+	case *parse.BoolNode, *parse.BreakNode, *parse.CommentNode, *parse.ContinueNode,
+		*parse.DotNode, *parse.FieldNode, *parse.IdentifierNode, *parse.NilNode,
+		*parse.NumberNode, *parse.StringNode, *parse.TextNode, *parse.VariableNode:
+		// Leaves.
+	default:
+		// Includes *parse.BranchNode, which is not a concrete node type
+		// that will be encountered. See IfNode, WithNode, and RangeNode
+		// instead.
+		return &UnknownNodeError{n}
+	}
+	return nil
+}
+
+// branch walks the children of an if, range or with. Dot in the List
+// of a with is the value of the pipeline, and dot in the List of a
+// range is the element type of the pipeline.
+func (w *walker) branch(n *parse.BranchNode) error {
+	nvars := len(w.s.Vars)
+	defer func() { w.s.Vars = w.s.Vars[:nvars] }()
+
+	dot := w.s.Dot
+	pipe := w.s.TypeOf(n.Pipe)
+	if n.NodeType == parse.NodeWith {
+		dot = pipe
+	}
+
+	if err := w.walk(n.Pipe); err != nil {
+		return err
+	}
+
+	if n.NodeType == parse.NodeRange {
+		key, elem := RangeTypes(pipe)
+		decl := w.s.Vars[nvars:]
+		switch len(decl) {
+		case 1:
+			decl[0].Type = elem
+		case 2:
+			decl[0].Type, decl[1].Type = key, elem
+		}
+		dot = elem
+	}
+
+	outer := w.s.Dot
+	w.s.Dot = dot
+	err := w.walk(n.List)
+	w.s.Dot = outer
+	if err != nil {
+		return err
+	}
+
+	if n.ElseList != nil {
+		return w.walk(n.ElseList)
+	}
+	return nil
+}
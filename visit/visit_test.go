@@ -0,0 +1,305 @@
+package visit
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+	"text/template/parse"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const src = `package p
+
+type User struct {
+	Name    string
+	Friends []*User
+	Tags    map[string]int
+}
+
+func (u *User) Greeting() string { return "" }
+
+type Page struct {
+	Title string
+	User  *User
+	Users []User
+}
+`
+
+// lookupType type-checks src and returns the type named name.
+func lookupType(name string) types.Type {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		panic(err)
+	}
+	conf := types.Config{Importer: importer.Default()}
+	pkg, err := conf.Check("p", fset, []*ast.File{f}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return pkg.Scope().Lookup(name).Type()
+}
+
+// parseTrees parses text with comments preserved and without
+// checking that functions are defined.
+func parseTrees(text string) map[string]*parse.Tree {
+	trees := make(map[string]*parse.Tree)
+	tr := parse.New("test")
+	tr.Mode = parse.ParseComments | parse.SkipFuncCheck
+	if _, err := tr.Parse(text, "", "", trees); err != nil {
+		panic(err)
+	}
+	return trees
+}
+
+// events walks the tree named "test" and returns the enter and leave
+// events, such as "enter *parse.FieldNode".
+func events(text string) ([]string, error) {
+	var ret []string
+	err := Walk(parseTrees(text)["test"], nil, Funcs{
+		EnterFunc: func(n parse.Node, _ *Scope) error {
+			ret = append(ret, fmt.Sprintf("enter %T", n))
+			return nil
+		},
+		LeaveFunc: func(n parse.Node, _ *Scope) error {
+			ret = append(ret, fmt.Sprintf("leave %T", n))
+			return nil
+		},
+	})
+	return ret, err
+}
+
+// scopeAt walks the tree named "test" and returns a copy of the scope
+// at the first field node with the ident.
+func scopeAt(text, tree, ident string, dot types.Type) *Scope {
+	var ret *Scope
+	Walk(parseTrees(text)[tree], dot, Funcs{EnterFunc: func(n parse.Node, s *Scope) error {
+		if f, ok := n.(*parse.FieldNode); ok && ret == nil && strings.Join(f.Ident, ".") == ident {
+			ret = s.Copy()
+		}
+		return nil
+	}})
+	return ret
+}
+
+func TestWalk(t *testing.T) {
+	tests := []struct {
+		kind string
+		text string
+	}{
+		{"*parse.ActionNode", "{{.X}}"},
+		{"*parse.BoolNode", "{{true}}"},
+		{"*parse.BreakNode", "{{range .X}}{{break}}{{end}}"},
+		{"*parse.ChainNode", "{{(.X).Y}}"},
+		{"*parse.CommandNode", "{{.X}}"},
+		{"*parse.CommentNode", "{{/* comment */}}"},
+		{"*parse.ContinueNode", "{{range .X}}{{continue}}{{end}}"},
+		{"*parse.DotNode", "{{.}}"},
+		{"*parse.FieldNode", "{{.X}}"},
+		{"*parse.IdentifierNode", "{{print 1}}"},
+		{"*parse.IfNode", "{{if .X}}{{end}}"},
+		{"*parse.ListNode", "text"},
+		{"*parse.NilNode", "{{print nil}}"},
+		{"*parse.NumberNode", "{{1}}"},
+		{"*parse.PipeNode", "{{.X}}"},
+		{"*parse.RangeNode", "{{range .X}}{{end}}"},
+		{"*parse.StringNode", `{{"s"}}`},
+		{"*parse.TemplateNode", `{{template "x" .}}`},
+		{"*parse.TextNode", "text"},
+		{"*parse.VariableNode", "{{$}}"},
+		{"*parse.WithNode", "{{with .X}}{{end}}"},
+	}
+
+	Convey("Walk", t, func() {
+		for _, tt := range tests {
+			tt := tt
+			Convey(tt.kind, func() {
+				ev, err := events(tt.text)
+				So(err, ShouldBeNil)
+				So(ev, ShouldContain, "enter "+tt.kind)
+				So(ev, ShouldContain, "leave "+tt.kind)
+			})
+		}
+
+		Convey("enter and leave order", func() {
+			ev, err := events("{{.X}}")
+			So(err, ShouldBeNil)
+			So(ev, ShouldResemble, []string{
+				"enter *parse.ListNode",
+				"enter *parse.ActionNode",
+				"enter *parse.PipeNode",
+				"enter *parse.CommandNode",
+				"enter *parse.FieldNode",
+				"leave *parse.FieldNode",
+				"leave *parse.CommandNode",
+				"leave *parse.PipeNode",
+				"leave *parse.ActionNode",
+				"leave *parse.ListNode",
+			})
+		})
+
+		Convey("else if and else with", func() {
+			ev, err := events("{{if .X}}{{else if .Y}}{{end}}{{with .X}}{{else with .Y}}{{end}}")
+			So(err, ShouldBeNil)
+			var branches []string
+			for _, e := range ev {
+				if strings.HasSuffix(e, "IfNode") || strings.HasSuffix(e, "WithNode") {
+					branches = append(branches, e)
+				}
+			}
+			So(branches, ShouldResemble, []string{
+				"enter *parse.IfNode", "enter *parse.IfNode", "leave *parse.IfNode", "leave *parse.IfNode",
+				"enter *parse.WithNode", "enter *parse.WithNode", "leave *parse.WithNode", "leave *parse.WithNode",
+			})
+		})
+
+		Convey("template without pipeline", func() {
+			ev, err := events(`{{template "x"}}`)
+			So(err, ShouldBeNil)
+			So(ev, ShouldContain, "enter *parse.TemplateNode")
+		})
+
+		Convey("SkipChildren", func() {
+			var ev []string
+			err := Walk(parseTrees("{{.X}}")["test"], nil, Funcs{
+				EnterFunc: func(n parse.Node, _ *Scope) error {
+					ev = append(ev, fmt.Sprintf("enter %T", n))
+					if _, ok := n.(*parse.ActionNode); ok {
+						return SkipChildren
+					}
+					return nil
+				},
+				LeaveFunc: func(n parse.Node, _ *Scope) error {
+					ev = append(ev, fmt.Sprintf("leave %T", n))
+					return nil
+				},
+			})
+			So(err, ShouldBeNil)
+			So(ev, ShouldResemble, []string{
+				"enter *parse.ListNode",
+				"enter *parse.ActionNode",
+				"leave *parse.ActionNode",
+				"leave *parse.ListNode",
+			})
+		})
+	})
+}
+
+func TestScope(t *testing.T) {
+	page := lookupType("Page")
+
+	Convey("Scope", t, func() {
+		Convey("dot at root", func() {
+			s := scopeAt("{{.Title}}", "test", "Title", page)
+			So(s.Dot, ShouldEqual, page)
+			So(s.TypeOf(s.Parent()), ShouldEqual, types.Typ[types.String])
+		})
+
+		Convey("dot in with", func() {
+			s := scopeAt("{{with .User}}{{.Name}}{{else}}{{.Title}}{{end}}", "test", "Name", page)
+			So(s.Dot.String(), ShouldEqual, "*p.User")
+			So(s.Enclosing(), ShouldHaveLength, 1)
+
+			s = scopeAt("{{with .User}}{{.Name}}{{else}}{{.Title}}{{end}}", "test", "Title", page)
+			So(s.Dot, ShouldEqual, page)
+		})
+
+		Convey("dot and variables in range", func() {
+			text := "{{range $i, $u := .Users}}{{range .Friends}}{{.Name}}{{end}}{{end}}"
+			s := scopeAt(text, "test", "Name", page)
+			So(s.Dot.String(), ShouldEqual, "*p.User")
+			So(s.Enclosing(), ShouldHaveLength, 2)
+
+			i, ok := s.Lookup("$i")
+			So(ok, ShouldBeTrue)
+			So(i.Type, ShouldEqual, types.Typ[types.Int])
+			u, ok := s.Lookup("$u")
+			So(ok, ShouldBeTrue)
+			So(u.Type.String(), ShouldEqual, "p.User")
+
+			root, ok := s.Lookup("$")
+			So(ok, ShouldBeTrue)
+			So(root.Type, ShouldEqual, page)
+		})
+
+		Convey("variables end with their list", func() {
+			text := "{{if .Title}}{{$t := .User}}{{$t.Name}}{{end}}{{.Users}}"
+			s := scopeAt(text, "test", "Users", page)
+			_, ok := s.Lookup("$t")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("variable types", func() {
+			var got types.Type
+			text := "{{$t := .User}}{{$t.Greeting}}"
+			Walk(parseTrees(text)["test"], page, Funcs{EnterFunc: func(n parse.Node, s *Scope) error {
+				if v, ok := n.(*parse.VariableNode); ok && len(v.Ident) == 2 {
+					got = s.TypeOf(v)
+				}
+				return nil
+			}})
+			So(got, ShouldEqual, types.Typ[types.String])
+		})
+
+		Convey("defining template", func() {
+			text := `{{define "row"}}{{.Name}}{{end}}{{template "row" .User}}`
+			s := scopeAt(text, "row", "Name", nil)
+			So(s.Template, ShouldEqual, "row")
+			So(s.Dot, ShouldBeNil)
+		})
+	})
+}
+
+func TestNames(t *testing.T) {
+	Convey("Names", t, func() {
+		So(Names(lookupType("Page")), ShouldResemble, []string{"Title", "User", "Users"})
+		So(Names(lookupType("User")), ShouldResemble, []string{"Friends", "Greeting", "Name", "Tags"})
+		So(Names(nil), ShouldBeNil)
+	})
+}
+
+func TestFields(t *testing.T) {
+	Convey("Fields", t, func() {
+		var names []string
+		for _, f := range Fields(types.NewPointer(lookupType("Page"))) {
+			names = append(names, f.Name())
+		}
+		So(names, ShouldResemble, []string{"Title", "User", "Users"})
+		So(Fields(types.Typ[types.String]), ShouldBeEmpty)
+	})
+}
+
+func TestTypeOf(t *testing.T) {
+	page := lookupType("Page")
+	s := &Scope{Dot: page, Vars: []Var{{Name: "$", Type: page}}}
+
+	typeOf := func(text string) string {
+		tr := parseTrees("{{" + text + "}}")["test"]
+		pipe := tr.Root.Nodes[0].(*parse.ActionNode).Pipe
+		if t := s.TypeOf(pipe); t != nil {
+			return t.String()
+		}
+		return "<nil>"
+	}
+
+	Convey("TypeOf", t, func() {
+		So(typeOf(".Title"), ShouldEqual, "string")
+		So(typeOf(".User.Friends"), ShouldEqual, "[]*p.User")
+		So(typeOf(".User.Tags.anything"), ShouldEqual, "int")
+		So(typeOf(".User.Greeting"), ShouldEqual, "string")
+		So(typeOf("(.User).Name"), ShouldEqual, "string")
+		So(typeOf("$.Users"), ShouldEqual, "[]p.User")
+		So(typeOf("index .Users 0"), ShouldEqual, "p.User")
+		So(typeOf("len .Users"), ShouldEqual, "int")
+		So(typeOf(".Title | print"), ShouldEqual, "string")
+		So(typeOf(".Missing"), ShouldEqual, "<nil>")
+		So(typeOf(".Title.Missing"), ShouldEqual, "<nil>")
+		So(typeOf("1.5"), ShouldEqual, "float64")
+	})
+}
@@ -0,0 +1,259 @@
+package tmplcheck
+
+import (
+	"context"
+	"errors"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Watch checks like Check, then polls the templates and the go files of
+// the packages every interval and checks again when any of them change,
+// until ctx is done or fn returns a non-nil error, which is returned.
+//
+// fn is called with the result of each check, or with the error if the
+// check could not be performed, for instance because a go file does not
+// compile while it is being edited. The same error is not reported again
+// until a check succeeds.
+//
+// Only the templates that changed are parsed and checked again, unless
+// templates were added or removed, which may change the findings of
+// Execute calls of other templates. The packages are loaded again only if
+// one of their go files changed, and then all templates are checked
+// again: the packages must be type-checked as a whole, and a change in
+// one file, such as to a struct passed to templates, can change the
+// findings of Execute calls in the others. Packages matching
+// cfg.Packages are found once, when Watch starts.
+func Watch(ctx context.Context, cfg *Config, interval time.Duration, fn func(*Result, error) error) error {
+	if cfg.Package == "" && len(cfg.Packages) == 0 {
+		return errors.New("tmplcheck: package import path is required")
+	}
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	paths := cfg.ImportPaths()
+	wd, err := os.Getwd() // for relative import paths
+	if err != nil {
+		return err
+	}
+	var dirs []string
+	for _, path := range paths {
+		pkg, err := build.Import(path, wd, build.FindOnly)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, pkg.Dir)
+	}
+
+	w := &watcher{
+		cfg:       cfg,
+		paths:     paths,
+		dirs:      dirs,
+		templates: make(map[string]stamp),
+		parsed:    make(map[string]parsedTemplate),
+		parseErrs: make(map[string]ParseError),
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res, err := w.check(ctx)
+		if res != nil || err != nil {
+			if err := fn(res, err); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// stamp identifies the contents of a file at some point in time.
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newStamp(info os.FileInfo) stamp {
+	return stamp{info.ModTime(), info.Size()}
+}
+
+func (s stamp) equal(t stamp) bool {
+	return s.modTime.Equal(t.modTime) && s.size == t.size
+}
+
+// watcher holds the state of Watch between checks.
+type watcher struct {
+	cfg   *Config
+	paths []string // import paths of the go packages
+	dirs  []string // directories of the go packages
+
+	loaded       bool
+	gofiles      map[string]stamp // file path -> stamp, when the packages were loaded
+	usages       map[string][]Usage
+	unverifiable []UnverifiableUsage
+	unattached   []UnusedSuppression
+
+	templates map[string]stamp // relative path -> stamp, when the template was parsed
+	parsed    map[string]parsedTemplate
+	parseErrs map[string]ParseError
+
+	results map[string]TemplateResult // template name -> result of the last check
+
+	lastErr string // last error returned by check
+}
+
+// check parses the changed files and returns the new result, or nil if
+// nothing changed.
+func (w *watcher) check(ctx context.Context) (*Result, error) {
+	gofiles, err := w.scanGo()
+	if err != nil {
+		return w.fail(err)
+	}
+	templates, err := w.scanTemplates()
+	if err != nil {
+		return w.fail(err)
+	}
+
+	all := w.results == nil          // whether all templates must be checked
+	changed := make(map[string]bool) // templates to check otherwise
+
+	if !w.loaded || !equalStamps(gofiles, w.gofiles) {
+		usages, unverifiable, unattached, err := parsePackages(ctx, w.paths)
+		if err != nil {
+			return w.fail(err)
+		}
+		w.loaded, w.gofiles = true, gofiles
+		w.usages, w.unverifiable, w.unattached = usages, unverifiable, unattached
+		all = true
+	}
+
+	for relp, st := range templates {
+		if old, ok := w.templates[relp]; ok && old.equal(st) {
+			continue
+		}
+		pt, pe, err := parseTemplateFile(relp, w.cfg)
+		if err != nil {
+			return w.fail(err)
+		}
+		if _, ok := w.templates[relp]; !ok {
+			all = true // added
+		}
+		delete(w.parsed, relp)
+		delete(w.parseErrs, relp)
+		if pe != nil {
+			w.parseErrs[relp] = *pe
+		} else {
+			w.parsed[relp] = pt
+		}
+		w.templates[relp] = st
+		changed[relp] = true
+	}
+	for relp := range w.templates {
+		if _, ok := templates[relp]; !ok {
+			delete(w.templates, relp)
+			delete(w.parsed, relp)
+			delete(w.parseErrs, relp)
+			all = true
+		}
+	}
+
+	if !all && len(changed) == 0 {
+		return nil, nil
+	}
+	w.lastErr = ""
+	if all {
+		changed = nil
+		w.results = make(map[string]TemplateResult)
+	}
+	res := doCheck(w.cfg, w.usages, w.unverifiable, w.unattached, w.parsed, w.parseErrs, changed)
+	for name := range changed {
+		delete(w.results, name)
+	}
+	for _, r := range res.Templates {
+		w.results[r.Template] = r
+	}
+
+	ret := &Result{}
+	for _, r := range w.results {
+		ret.Templates = append(ret.Templates, r)
+	}
+	ret.Sort()
+	return ret, nil
+}
+
+// fail returns err, unless it is the same as the last error returned.
+func (w *watcher) fail(err error) (*Result, error) {
+	if err.Error() == w.lastErr {
+		return nil, nil
+	}
+	w.lastErr = err.Error()
+	return nil, err
+}
+
+// scanGo returns the stamps of the go files, excluding tests, in the
+// directories of the packages.
+func (w *watcher) scanGo() (map[string]stamp, error) {
+	ret := make(map[string]stamp)
+	for _, dir := range w.dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			ret[filepath.Join(dir, name)] = newStamp(info)
+		}
+	}
+	return ret, nil
+}
+
+// scanTemplates returns the stamps of the templates, keyed by path
+// relative to the templates directory.
+func (w *watcher) scanTemplates() (map[string]stamp, error) {
+	ret := make(map[string]stamp)
+	root := w.cfg.Templates
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relp, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if w.cfg.includes(relp) {
+			ret[relp] = newStamp(info)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func equalStamps(a, b map[string]stamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, s := range a {
+		t, ok := b[k]
+		if !ok || !s.equal(t) {
+			return false
+		}
+	}
+	return true
+}
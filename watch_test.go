@@ -0,0 +1,79 @@
+package tmplcheck
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWatch(t *testing.T) {
+	Convey("watch checks again when a template changes", t, func() {
+		dir, err := ioutil.TempDir("", "tmplcheck")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "templates", "root.html"))
+		So(err, ShouldBeNil)
+		root := filepath.Join(dir, "root.html")
+		So(ioutil.WriteFile(root, b, 0644), ShouldBeNil)
+		side := filepath.Join(dir, "side.html")
+		So(ioutil.WriteFile(side, []byte("{{.Z}}"), 0644), ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		results := make(chan *Result)
+		done := make(chan error, 1)
+		go func() {
+			done <- Watch(ctx, &Config{
+				Package:   "github.com/go-web-framework/tmplcheck/testdata/src",
+				Templates: dir,
+			}, 10*time.Millisecond, func(res *Result, err error) error {
+				if err != nil {
+					return err
+				}
+				results <- res
+				return nil
+			})
+		}()
+
+		// missing waits for the next result and returns the missing keys.
+		missing := func() []string {
+			var res *Result
+			select {
+			case res = <-results:
+			case err := <-done:
+				So(err, ShouldBeNil)
+			case <-time.After(time.Minute):
+				So("timed out", ShouldBeEmpty)
+			}
+			var ret []string
+			for _, r := range res.Templates {
+				for _, m := range r.Missing {
+					ret = append(ret, m.MissingKey)
+				}
+			}
+			return ret
+		}
+
+		So(missing(), ShouldResemble, []string{"Title", "X", "Y"})
+
+		// Only side.html is checked again; the findings of root.html are
+		// kept.
+		So(ioutil.WriteFile(side, []byte("{{.Z}} {{.W}}"), 0644), ShouldBeNil)
+		So(missing(), ShouldResemble, []string{"Title", "X", "Y"})
+
+		So(ioutil.WriteFile(root, []byte("<title>{{.Title}}</title>"), 0644), ShouldBeNil)
+		So(missing(), ShouldResemble, []string{"Title"})
+
+		So(os.Remove(root), ShouldBeNil)
+		So(missing(), ShouldBeEmpty)
+
+		cancel()
+		So(<-done, ShouldEqual, context.Canceled)
+	})
+}